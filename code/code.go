@@ -4,12 +4,33 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"monkey/token"
 )
 
 type Instructions []byte
 
 type Opcode byte
 
+// Positions maps instructions back to the source they were compiled from.
+// An entry covers the instructions from its offset up to the next entry.
+type Positions []SourcePosition
+
+type SourcePosition struct {
+	Offset int
+	Pos    token.Position
+}
+
+// Lookup returns the position of the instruction at offset, or the zero
+// position if it isn't known.
+func (p Positions) Lookup(offset int) token.Position {
+	for i := len(p) - 1; i >= 0; i-- {
+		if p[i].Offset <= offset {
+			return p[i].Pos
+		}
+	}
+	return token.Position{}
+}
+
 type Definition struct {
 	Name          string
 	OperandWidths []int
@@ -35,28 +56,82 @@ const (
 	OpNull
 	OpSetGlobal
 	OpGetGlobal
+	OpArray
+	OpHash
+	OpIndex
+	OpCall
+	OpReturnValue
+	OpReturn
+	OpGetLocal
+	OpSetLocal
+	OpClosure
+	OpGetFree
+	OpCurrentClosure
+	OpGetBuiltin
+	OpSetFree
+	OpSetIndex
+	OpGreaterThanEqual
+	OpIter
+	OpIterNext
+	OpIterValues
+	OpSetupTry
+	OpEndTry
+	OpCatch
+	OpThrow
+	OpDefineLocal
+	OpCaptureLocal
+	OpCaptureFree
+	OpLessThan
+	OpLessThanEqual
 )
 
 var definitions = map[Opcode]*Definition{
-	OpConstant:      {"OpConstant", []int{2}},
-	OpAdd:           {"OpAdd", []int{}},
-	OpSub:           {"OpSub", []int{}},
-	OpMul:           {"OpMul", []int{}},
-	OpDiv:           {"OpDiv", []int{}},
-	OpMod:           {"OpMod", []int{}},
-	OpPop:           {"OpPop", []int{}},
-	OpTrue:          {"OpTrue", []int{}},
-	OpFalse:         {"OpFalse", []int{}},
-	OpEqual:         {"OpEqual", []int{}},
-	OpNotEqual:      {"OpNotEqual", []int{}},
-	OpGreaterThan:   {"OpGreaterThan", []int{}},
-	OpMinus:         {"OpMinus", []int{}},
-	OpBang:          {"OpBang", []int{}},
-	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
-	OpJump:          {"OpJump", []int{2}},
-	OpNull:          {"OpNull", []int{}},
-	OpSetGlobal:     {"OpSetGlobal", []int{2}},
-	OpGetGlobal:     {"OpGetGlobal", []int{2}},
+	OpConstant:         {"OpConstant", []int{2}},
+	OpAdd:              {"OpAdd", []int{}},
+	OpSub:              {"OpSub", []int{}},
+	OpMul:              {"OpMul", []int{}},
+	OpDiv:              {"OpDiv", []int{}},
+	OpMod:              {"OpMod", []int{}},
+	OpPop:              {"OpPop", []int{}},
+	OpTrue:             {"OpTrue", []int{}},
+	OpFalse:            {"OpFalse", []int{}},
+	OpEqual:            {"OpEqual", []int{}},
+	OpNotEqual:         {"OpNotEqual", []int{}},
+	OpGreaterThan:      {"OpGreaterThan", []int{}},
+	OpMinus:            {"OpMinus", []int{}},
+	OpBang:             {"OpBang", []int{}},
+	OpJumpNotTruthy:    {"OpJumpNotTruthy", []int{2}},
+	OpJump:             {"OpJump", []int{2}},
+	OpNull:             {"OpNull", []int{}},
+	OpSetGlobal:        {"OpSetGlobal", []int{2}},
+	OpGetGlobal:        {"OpGetGlobal", []int{2}},
+	OpArray:            {"OpArray", []int{2}},
+	OpHash:             {"OpHash", []int{2}},
+	OpIndex:            {"OpIndex", []int{}},
+	OpCall:             {"OpCall", []int{1}},
+	OpReturnValue:      {"OpReturnValue", []int{}},
+	OpReturn:           {"OpReturn", []int{}},
+	OpGetLocal:         {"OpGetLocal", []int{1}},
+	OpSetLocal:         {"OpSetLocal", []int{1}},
+	OpClosure:          {"OpClosure", []int{2, 1}},
+	OpGetFree:          {"OpGetFree", []int{1}},
+	OpCurrentClosure:   {"OpCurrentClosure", []int{}},
+	OpGetBuiltin:       {"OpGetBuiltin", []int{1}},
+	OpSetFree:          {"OpSetFree", []int{1}},
+	OpSetIndex:         {"OpSetIndex", []int{}},
+	OpGreaterThanEqual: {"OpGreaterThanEqual", []int{}},
+	OpIter:             {"OpIter", []int{}},
+	OpIterNext:         {"OpIterNext", []int{}},
+	OpIterValues:       {"OpIterValues", []int{1}},
+	OpSetupTry:         {"OpSetupTry", []int{2}},
+	OpEndTry:           {"OpEndTry", []int{}},
+	OpCatch:            {"OpCatch", []int{}},
+	OpThrow:            {"OpThrow", []int{}},
+	OpDefineLocal:      {"OpDefineLocal", []int{1}},
+	OpCaptureLocal:     {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:      {"OpCaptureFree", []int{1}},
+	OpLessThan:         {"OpLessThan", []int{}},
+	OpLessThanEqual:    {"OpLessThanEqual", []int{}},
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -68,18 +143,22 @@ func Lookup(op byte) (*Definition, error) {
 	return def, nil
 }
 
+// Width is the length in bytes of an instruction with its operands
+func (def *Definition) Width() int {
+	width := 1
+	for _, w := range def.OperandWidths {
+		width += w
+	}
+	return width
+}
+
 func Make(op Opcode, operands ...int) []byte {
 	def, ok := definitions[op]
 	if !ok {
 		return []byte{}
 	}
 
-	instructionLen := 1
-	for _, w := range def.OperandWidths {
-		instructionLen += w
-	}
-
-	instruction := make([]byte, instructionLen)
+	instruction := make([]byte, def.Width())
 	instruction[0] = byte(op)
 
 	offset := 1
@@ -88,6 +167,8 @@ func Make(op Opcode, operands ...int) []byte {
 		switch width {
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
 		}
 		offset += width
 	}
@@ -102,10 +183,16 @@ func (ins Instructions) String() string {
 	for i < len(ins) {
 		def, err := Lookup(ins[i])
 		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			fmt.Fprintf(&out, "%04d ERROR: %s\n", i, err)
+			i++
 			continue
 		}
 
+		if i+def.Width() > len(ins) {
+			fmt.Fprintf(&out, "%04d ERROR: %s is missing operands\n", i, def.Name)
+			break
+		}
+
 		operands, read := ReadOperands(def, ins[i+1:])
 		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstructions(def, operands))
 
@@ -127,6 +214,8 @@ func (ins Instructions) fmtInstructions(def *Definition, operands []int) string
 		return def.Name
 	case 1:
 		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
 	}
 
 	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
@@ -139,6 +228,8 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 		switch width {
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
 		}
 		offset += width
 	}
@@ -149,3 +240,7 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
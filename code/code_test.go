@@ -1,6 +1,9 @@
 package code
 
-import "testing"
+import (
+	"monkey/token"
+	"testing"
+)
 
 func TestMake(t *testing.T) {
 	tests := []struct {
@@ -10,6 +13,10 @@ func TestMake(t *testing.T) {
 	}{
 		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpIterValues, []int{2}, []byte{byte(OpIterValues), 2}},
+		{OpSetupTry, []int{300}, []byte{byte(OpSetupTry), 1, 44}},
 	}
 
 	for _, tt := range tests {
@@ -30,15 +37,17 @@ func TestMake(t *testing.T) {
 func TestInstructionsString(t *testing.T) {
 	instructions := []Instructions{
 		Make(OpAdd),
-		Make(OpConstant, 1),
+		Make(OpGetLocal, 1),
 		Make(OpConstant, 2),
 		Make(OpConstant, 65335),
+		Make(OpClosure, 65535, 255),
 	}
 
 	expected := `0000 OpAdd
-0001 OpConstant 1
-0004 OpConstant 2
-0007 OpConstant 65335
+0001 OpGetLocal 1
+0003 OpConstant 2
+0006 OpConstant 65335
+0009 OpClosure 65535 255
 `
 
 	concatted := Instructions{}
@@ -51,6 +60,24 @@ func TestInstructionsString(t *testing.T) {
 	}
 }
 
+func TestInstructionsStringInvalid(t *testing.T) {
+	concatted := Instructions{}
+	concatted = append(concatted, Make(OpAdd)...)
+	concatted = append(concatted, 255)
+	concatted = append(concatted, Make(OpPop)...)
+	concatted = append(concatted, byte(OpConstant), 1)
+
+	expected := `0000 OpAdd
+0001 ERROR: opcode 255 undefined
+0002 OpPop
+0003 ERROR: OpConstant is missing operands
+`
+
+	if concatted.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q", expected, concatted.String())
+	}
+}
+
 func TestReadOperands(t *testing.T) {
 	tests := []struct {
 		op        Opcode
@@ -58,6 +85,8 @@ func TestReadOperands(t *testing.T) {
 		bytesRead int
 	}{
 		{OpConstant, []int{65335}, 2},
+		{OpGetLocal, []int{255}, 1},
+		{OpClosure, []int{65535, 255}, 3},
 	}
 
 	for _, tt := range tests {
@@ -80,3 +109,27 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestPositionsLookup(t *testing.T) {
+	positions := Positions{
+		{Offset: 0, Pos: token.Position{Line: 1, Column: 1}},
+		{Offset: 4, Pos: token.Position{Line: 2, Column: 3}},
+	}
+
+	tests := []struct {
+		offset   int
+		expected token.Position
+	}{
+		{-1, token.Position{}},
+		{0, token.Position{Line: 1, Column: 1}},
+		{3, token.Position{Line: 1, Column: 1}},
+		{4, token.Position{Line: 2, Column: 3}},
+		{10, token.Position{Line: 2, Column: 3}},
+	}
+
+	for _, tt := range tests {
+		if got := positions.Lookup(tt.offset); got != tt.expected {
+			t.Errorf("wrong position for offset %d. want=%+v, got=%+v", tt.offset, tt.expected, got)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"monkey/ast"
 	"monkey/code"
 	"monkey/object"
+	"monkey/token"
+	"sort"
 )
 
 type EmittedInstructions struct {
@@ -13,25 +15,74 @@ type EmittedInstructions struct {
 }
 
 type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	// position of the innermost node being compiled
+	pos token.Position
+}
+
+// Each function body is compiled into its own scope, so its instructions
+// don't end up in the enclosing instruction stream
+type CompilationScope struct {
 	instructions        code.Instructions
-	constants           []object.Object
 	lastInstruction     EmittedInstructions
 	previousInstruction EmittedInstructions
-	symbolTable         *SymbolTable
+	loops               []*LoopContext
+	tries               []*TryContext
+	positions           code.Positions
+}
+
+// The innermost loop being compiled. Continue jumps straight back to start,
+// break jumps are patched once the end of the loop is known.
+type LoopContext struct {
+	start  int
+	breaks []int
+	tries  int // number of enclosing tries outside of the loop
 }
 
+// A try enclosing the code being compiled. Leaving it early with return,
+// break or continue has to remove its handler and run its finally block.
+type TryContext struct {
+	finally *ast.BlockStatement
+	handler bool // a handler covers the code being compiled
+}
+
+// Limits of the one byte operands of OpCall, OpGetLocal, OpSetLocal and
+// OpClosure
+const (
+	maxArguments = 255
+	maxLocals    = 256
+	maxFree      = 255
+)
+
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	Positions    code.Positions
+	NumLocals    int // slots the main frame needs for variables of blocks
 }
 
 func New() *Compiler {
-	return &Compiler{
+	mainScope := CompilationScope{
 		instructions:        code.Instructions{},
-		constants:           []object.Object{},
 		lastInstruction:     EmittedInstructions{},
 		previousInstruction: EmittedInstructions{},
-		symbolTable:         NewSymbolTable(),
+	}
+
+	symbolTable := NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
 	}
 }
 
@@ -43,14 +94,31 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	// Instructions are attributed to the innermost node they belong to
+	pos := c.pos
+	if nodePos := node.Pos(); nodePos.IsValid() {
+		c.pos = nodePos
+	}
+
+	err := c.compile(node)
+	c.pos = pos
+	return err
+}
+
+func (c *Compiler) compile(node ast.Node) error {
 	switch node := node.(type) {
 	case *ast.Program:
+		// Every run of a program gets a new main frame for its blocks
+		c.symbolTable.numMainLocals = 0
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
 				return err
 			}
 		}
+		if c.symbolTable.numMainLocals > maxLocals {
+			return fmt.Errorf("%s: too many local variables, the blocks of a program can have at most %d", node.Pos(), maxLocals)
+		}
 
 	case *ast.ExpressionStatement:
 		err := c.Compile(node.Expression)
@@ -60,18 +128,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpPop)
 
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			err := c.Compile(node.Right)
-			if err != nil {
-				return err
-			}
+		if node.Operator == "=" {
+			return c.compileAssignment(node.Left, node.Right)
+		}
 
-			err = c.Compile(node.Left)
-			if err != nil {
-				return err
-			}
-			c.emit(code.OpGreaterThan)
-			return nil
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
 		}
 
 		err := c.Compile(node.Left)
@@ -97,12 +159,18 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMod)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterThanEqual)
+		case "<":
+			c.emit(code.OpLessThan)
+		case "<=":
+			c.emit(code.OpLessThanEqual)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
 		default:
-			return fmt.Errorf("unknown operator: %s", node.Operator)
+			return fmt.Errorf("%s: unknown operator: %s", node.Pos(), node.Operator)
 		}
 
 	case *ast.IntegerLiteral:
@@ -113,6 +181,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		float := &object.Float{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(float))
 
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -132,7 +204,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "-":
 			c.emit(code.OpMinus)
 		default:
-			return fmt.Errorf("unknown operator: %s", node.Operator)
+			return fmt.Errorf("%s: unknown operator: %s", node.Pos(), node.Operator)
 		}
 
 	case *ast.IfExpression:
@@ -148,13 +220,16 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if c.lastInstructionIsPop() {
+		// A branch ending in a statement like let has no value
+		if c.lastInstructionIs(code.OpPop) {
 			c.removeLastPop()
+		} else {
+			c.emit(code.OpNull)
 		}
 
 		jumpPos := c.emit(code.OpJump, 9999)
 
-		afterConsequencePos := len(c.instructions)
+		afterConsequencePos := len(c.currentInstructions())
 		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
 
 		if node.Alternative == nil {
@@ -165,44 +240,419 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
-			if c.lastInstructionIsPop() {
+			if c.lastInstructionIs(code.OpPop) {
 				c.removeLastPop()
+			} else {
+				c.emit(code.OpNull)
 			}
 		}
 
-		afterAlternativePos := len(c.instructions)
+		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
 
-	case *ast.BlockStatement:
-		for _, s := range node.Statements {
-			err := c.Compile(s)
+	case *ast.LoopExpression:
+		// The loop evaluates to the value of the last iteration's body, or
+		// null if the body never ran. That value is kept on the stack and
+		// replaced on every iteration.
+		c.emit(code.OpNull)
+
+		loopStartPos := len(c.currentInstructions())
+
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		c.emit(code.OpPop)
+
+		err = c.compileLoopBody(node.Body, loopStartPos, jumpNotTruthyPos)
+		if err != nil {
+			return err
+		}
+
+	case *ast.ForExpression:
+		err := c.Compile(node.Iterable)
+		if err != nil {
+			return err
+		}
+
+		// The loop variables and the iterator, kept in a variable that can't
+		// clash with the program's names, are scoped to the loop
+		c.enterBlock()
+
+		c.emit(code.OpIter)
+		iterator := c.symbolTable.Define("@iterator")
+		c.storeSymbol(iterator)
+
+		// Same layout as a loop expression, advancing the iterator is the
+		// condition
+		c.emit(code.OpNull)
+
+		loopStartPos := len(c.currentInstructions())
+
+		c.loadSymbol(iterator)
+		c.emit(code.OpIterNext)
+
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		c.emit(code.OpPop)
+
+		variables := []*ast.Identifier{node.Value}
+		if node.Key != nil {
+			variables = append(variables, node.Key)
+		}
+
+		c.loadSymbol(iterator)
+		c.emit(code.OpIterValues, len(variables))
+
+		// The value is on top of the key
+		for _, variable := range variables {
+			symbol, err := c.define(variable, false)
 			if err != nil {
 				return err
 			}
+			c.storeSymbol(symbol)
+		}
+
+		err = c.compileLoopBody(node.Body, loopStartPos, jumpNotTruthyPos)
+		if err != nil {
+			return err
+		}
+
+		c.leaveBlock()
+
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("%s: break outside of loop", node.Pos())
+		}
+
+		err := c.exitTries(loop.tries, false)
+		if err != nil {
+			return err
+		}
+
+		// The body's value was popped at the start of the iteration, so
+		// the loop needs a replacement before jumping out of it
+		c.emit(code.OpNull)
+		pos := c.emit(code.OpJump, 9999)
+		loop.breaks = append(loop.breaks, pos)
+
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("%s: continue outside of loop", node.Pos())
+		}
+
+		err := c.exitTries(loop.tries, false)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpNull)
+		c.emit(code.OpJump, loop.start)
+
+	case *ast.BlockStatement:
+		c.enterBlock()
+		err := c.compileStatements(node.Statements)
+		if err != nil {
+			return err
 		}
+		c.leaveBlock()
 
 	case *ast.LetStatement:
+		// Declaring a name again in the same scope changes the same
+		// variable, closures that captured it see the new value
+		redeclared := c.symbolTable.isDefined(node.Name.Value)
+
+		// A function can assign to the variable it is stored in, so that
+		// variable is defined before the function is compiled
+		_, isFunction := node.Value.(*ast.FunctionLiteral)
+		var symbol Symbol
+		if isFunction {
+			var err error
+			symbol, err = c.define(node.Name, node.IsConst())
+			if err != nil {
+				return err
+			}
+			if symbol.Scope == LocalScope && !redeclared {
+				c.emit(code.OpNull)
+				c.emit(code.OpDefineLocal, symbol.Index)
+				redeclared = true
+			}
+		}
+
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
-		symbol := c.symbolTable.Define(node.Name.Value)
-		c.emit(code.OpSetGlobal, symbol.Index)
+
+		if !isFunction {
+			symbol, err = c.define(node.Name, node.IsConst())
+			if err != nil {
+				return err
+			}
+		}
+
+		if redeclared && symbol.Scope == LocalScope {
+			c.emit(code.OpSetLocal, symbol.Index)
+		} else {
+			c.storeSymbol(symbol)
+		}
 
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return fmt.Errorf("%s: undefined variable %s", node.Pos(), node.Value)
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			err := c.Compile(el)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		keys := []ast.Expression{}
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+
+		// Go doesn't guarantee map iteration order, sort the keys so the
+		// emitted instructions are deterministic
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		for _, k := range keys {
+			err := c.Compile(k)
+			if err != nil {
+				return err
+			}
+			err = c.Compile(node.Pairs[k])
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.IndexExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(node.Index)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+
+	case *ast.FunctionLiteral:
+		c.enterScope()
+
+		if node.Name != "" {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+
+		for _, p := range node.Parameters {
+			c.symbolTable.Define(p.Value)
+		}
+
+		// The body shares the scope of the parameters
+		err := c.compileStatements(node.Body.Statements)
+		if err != nil {
+			return err
+		}
+
+		// The last expression of the body is the implicit return value
+		if c.lastInstructionIs(code.OpPop) {
+			c.replaceLastPopWithReturn()
+		}
+		if !c.lastInstructionIs(code.OpReturnValue) {
+			c.emit(code.OpReturn)
+		}
+
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.numDefinitions
+		if numLocals > maxLocals {
+			return fmt.Errorf("%s: too many local variables, a function can have at most %d", node.Pos(), maxLocals)
+		}
+		if len(freeSymbols) > maxFree {
+			return fmt.Errorf("%s: too many free variables, a function can capture at most %d", node.Pos(), maxFree)
+		}
+		positions := c.scopes[c.scopeIndex].positions
+		instructions := c.leaveScope()
+
+		// Push the captured variables in the enclosing scope, OpClosure
+		// collects them into the closure
+		for _, s := range freeSymbols {
+			c.captureSymbol(s)
+		}
+
+		compiledFn := &object.CompiledFunction{
+			Instructions:  instructions,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			Name:          node.Name,
+			Positions:     positions,
+		}
+		fnIndex := c.addConstant(compiledFn)
+		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+
+	case *ast.ReturnStatement:
+		err := c.Compile(node.ReturnValue)
+		if err != nil {
+			return err
+		}
+
+		err = c.exitTries(0, true)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+
+	case *ast.TryExpression:
+		err := c.compileTry(node)
+		if err != nil {
+			return err
+		}
+
+	case *ast.CallExpression:
+		if len(node.Arguments) > maxArguments {
+			return fmt.Errorf("%s: too many arguments, a call can have at most %d", node.Pos(), maxArguments)
+		}
+
+		err := c.Compile(node.Function)
+		if err != nil {
+			return err
+		}
+
+		for _, a := range node.Arguments {
+			err := c.Compile(a)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpCall, len(node.Arguments))
+	}
+	return nil
+}
+
+// Logical operators short-circuit: the right operand is jumped over when the
+// left one already decides the result. Both evaluate to a boolean.
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	var jumpToFalsePos []int
+	var jumpToEndPos []int
+
+	if node.Operator == "&&" {
+		jumpToFalsePos = append(jumpToFalsePos, c.emit(code.OpJumpNotTruthy, 9999))
+	} else {
+		jumpToRightPos := c.emit(code.OpJumpNotTruthy, 9999)
+		c.emit(code.OpTrue)
+		jumpToEndPos = append(jumpToEndPos, c.emit(code.OpJump, 9999))
+		c.changeOperand(jumpToRightPos, len(c.currentInstructions()))
+	}
+
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+
+	jumpToFalsePos = append(jumpToFalsePos, c.emit(code.OpJumpNotTruthy, 9999))
+	c.emit(code.OpTrue)
+	jumpToEndPos = append(jumpToEndPos, c.emit(code.OpJump, 9999))
+
+	falsePos := c.emit(code.OpFalse)
+	for _, pos := range jumpToFalsePos {
+		c.changeOperand(pos, falsePos)
+	}
+
+	afterPos := len(c.currentInstructions())
+	for _, pos := range jumpToEndPos {
+		c.changeOperand(pos, afterPos)
+	}
+
+	return nil
+}
+
+// An assignment is an expression, it leaves the assigned value on the stack
+func (c *Compiler) compileAssignment(left ast.Expression, right ast.Expression) error {
+	switch left := left.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.ResolveVariable(left.Value)
+		if !ok {
+			return fmt.Errorf("%s: undefined variable %s", left.Pos(), left.Value)
 		}
-		c.emit(code.OpGetGlobal, symbol.Index)
+		if symbol.Constant {
+			return fmt.Errorf("%s: cannot assign to constant %s", left.Pos(), left.Value)
+		}
+
+		err := c.Compile(right)
+		if err != nil {
+			return err
+		}
+
+		switch symbol.Scope {
+		case GlobalScope:
+			c.emit(code.OpSetGlobal, symbol.Index)
+		case LocalScope:
+			c.emit(code.OpSetLocal, symbol.Index)
+		case FreeScope:
+			c.emit(code.OpSetFree, symbol.Index)
+		default:
+			return fmt.Errorf("%s: cannot assign to %s", left.Pos(), left.Value)
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.IndexExpression:
+		err := c.Compile(left.Left)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(left.Index)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(right)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex)
+
+	default:
+		return fmt.Errorf("%s: invalid identifier: %s", left.Pos(), left.String())
 	}
+
 	return nil
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
-		Instructions: c.instructions,
+		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Positions:    c.scopes[c.scopeIndex].positions,
+		NumLocals:    c.symbolTable.numMainLocals,
 	}
 }
 
@@ -218,39 +668,389 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	return pos
 }
 
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
 func (c *Compiler) addInstructions(ins []byte) int {
-	posNewInstruction := len(c.instructions)
-	c.instructions = append(c.instructions, ins...)
+	posNewInstruction := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+	c.addPosition(posNewInstruction)
 	return posNewInstruction
 }
 
+// addPosition records the current position for the instruction at offset,
+// unless the previous instructions already have it
+func (c *Compiler) addPosition(offset int) {
+	positions := c.scopes[c.scopeIndex].positions
+	if len(positions) > 0 && positions[len(positions)-1].Pos == c.pos {
+		return
+	}
+	c.scopes[c.scopeIndex].positions = append(positions, code.SourcePosition{Offset: offset, Pos: c.pos})
+}
+
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
-	previous := c.lastInstruction
+	previous := c.scopes[c.scopeIndex].lastInstruction
 	last := EmittedInstructions{OpCode: op, Position: pos}
 
-	c.previousInstruction = previous
-	c.lastInstruction = last
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
 }
 
-func (c *Compiler) lastInstructionIsPop() bool {
-	return c.lastInstruction.OpCode == code.OpPop
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.OpCode == op
 }
 
 func (c *Compiler) removeLastPop() {
-	c.instructions = c.instructions[:c.lastInstruction.Position]
-	c.lastInstruction = c.previousInstruction
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	c.scopes[c.scopeIndex].instructions = c.currentInstructions()[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+
+	positions := c.scopes[c.scopeIndex].positions
+	for len(positions) > 0 && positions[len(positions)-1].Offset >= last.Position {
+		positions = positions[:len(positions)-1]
+	}
+	c.scopes[c.scopeIndex].positions = positions
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+
+	c.scopes[c.scopeIndex].lastInstruction.OpCode = code.OpReturnValue
 }
 
 // Can replace instructions with same type and same non-variable length
 func (c *Compiler) changeOperand(opPos int, operand int) {
-	op := code.Opcode(c.instructions[opPos])
+	op := code.Opcode(c.currentInstructions()[opPos])
 	newInstruction := code.Make(op, operand)
 
 	c.replaceInstruction(opPos, newInstruction)
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
 	for i := 0; i < len(newInstruction); i++ {
-		c.instructions[pos+i] = newInstruction[i]
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstructions{},
+		previousInstruction: EmittedInstructions{},
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+// compileLoopBody compiles the body of a loop whose condition has been
+// checked, jumping back to the start afterwards and patching the jumps that
+// leave the loop.
+func (c *Compiler) compileLoopBody(body *ast.BlockStatement, loopStartPos int, jumpNotTruthyPos int) error {
+	loop := c.enterLoop(loopStartPos)
+	err := c.Compile(body)
+	if err != nil {
+		return err
+	}
+	c.leaveLoop()
+
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+
+	c.emit(code.OpJump, loopStartPos)
+
+	afterBodyPos := len(c.currentInstructions())
+	c.changeOperand(jumpNotTruthyPos, afterBodyPos)
+	for _, pos := range loop.breaks {
+		c.changeOperand(pos, afterBodyPos)
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileStatements(stmts []ast.Statement) error {
+	for _, s := range stmts {
+		err := c.Compile(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileTry lays out a try expression as
+//
+//	OpSetupTry catch (or rethrow without a catch block)
+//	body
+//	OpEndTry
+//	OpJump finally
+//	catch:           the VM pushes the error and jumps here
+//	OpCatch, bound to the parameter
+//	OpSetupTry rethrow, if there is a finally block
+//	catch block
+//	OpEndTry
+//	finally:
+//	finally block
+//	OpJump end
+//	rethrow:         errors that weren't caught run finally as well
+//	finally block
+//	OpThrow
+//	end:
+//
+// leaving the value of the body or the catch block on the stack.
+func (c *Compiler) compileTry(node *ast.TryExpression) error {
+	try := c.enterTry(node.Finally)
+
+	try.handler = true
+	setupPos := c.emit(code.OpSetupTry, 9999)
+
+	err := c.Compile(node.Body)
+	if err != nil {
+		return err
+	}
+	c.keepLastValue()
+
+	c.emit(code.OpEndTry)
+	try.handler = false
+
+	// Both the handler of the body and the one of the catch block rethrow
+	// through the finally block
+	rethrowSetups := []int{}
+
+	if node.Catch != nil {
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(setupPos, len(c.currentInstructions()))
+
+		c.enterBlock()
+
+		if node.Param != nil {
+			c.emit(code.OpCatch)
+			symbol, err := c.define(node.Param, false)
+			if err != nil {
+				return err
+			}
+			c.storeSymbol(symbol)
+		} else {
+			c.emit(code.OpPop)
+		}
+
+		if node.Finally != nil {
+			try.handler = true
+			rethrowSetups = append(rethrowSetups, c.emit(code.OpSetupTry, 9999))
+		}
+
+		err := c.Compile(node.Catch)
+		if err != nil {
+			return err
+		}
+		c.keepLastValue()
+
+		if node.Finally != nil {
+			c.emit(code.OpEndTry)
+			try.handler = false
+		}
+
+		c.leaveBlock()
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+	} else {
+		rethrowSetups = append(rethrowSetups, setupPos)
+	}
+
+	c.leaveTry()
+
+	if node.Finally != nil {
+		err := c.compileFinally(node.Finally)
+		if err != nil {
+			return err
+		}
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		for _, pos := range rethrowSetups {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+
+		err = c.compileFinally(node.Finally)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+	}
+
+	return nil
+}
+
+// compileFinally compiles a finally block that runs while the value of the
+// try, the error to rethrow or the value to return waits on the stack. The
+// value is kept in a hidden local meanwhile, a break or continue in the
+// block would leave it behind on the stack otherwise.
+func (c *Compiler) compileFinally(finally *ast.BlockStatement) error {
+	c.enterBlock()
+	pending := c.symbolTable.Define("@pending")
+	c.storeSymbol(pending)
+
+	err := c.Compile(finally)
+	if err != nil {
+		return err
+	}
+
+	c.loadSymbol(pending)
+	c.leaveBlock()
+	return nil
+}
+
+// keepLastValue leaves the value of the block just compiled on the stack,
+// null if it ends with a statement like let
+func (c *Compiler) keepLastValue() {
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+}
+
+// exitTries leaves the innermost tries down to the given number of them,
+// before return, break or continue jump out of them. A return value waiting
+// on the stack is kept while the finally blocks run.
+func (c *Compiler) exitTries(depth int, returning bool) error {
+	tries := c.scopes[c.scopeIndex].tries
+
+	for i := len(tries) - 1; i >= depth; i-- {
+		if tries[i].handler {
+			c.emit(code.OpEndTry)
+		}
+
+		if tries[i].finally != nil {
+			// The finally block isn't covered by its own try
+			c.scopes[c.scopeIndex].tries = append([]*TryContext{}, tries[:i]...)
+			var err error
+			if returning {
+				err = c.compileFinally(tries[i].finally)
+			} else {
+				err = c.Compile(tries[i].finally)
+			}
+			c.scopes[c.scopeIndex].tries = tries
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Compiler) enterTry(finally *ast.BlockStatement) *TryContext {
+	try := &TryContext{finally: finally}
+	c.scopes[c.scopeIndex].tries = append(c.scopes[c.scopeIndex].tries, try)
+	return try
+}
+
+func (c *Compiler) leaveTry() {
+	tries := c.scopes[c.scopeIndex].tries
+	c.scopes[c.scopeIndex].tries = tries[:len(tries)-1]
+}
+
+func (c *Compiler) enterLoop(start int) *LoopContext {
+	loop := &LoopContext{start: start, tries: len(c.scopes[c.scopeIndex].tries)}
+	c.scopes[c.scopeIndex].loops = append(c.scopes[c.scopeIndex].loops, loop)
+	return loop
+}
+
+func (c *Compiler) leaveLoop() {
+	loops := c.scopes[c.scopeIndex].loops
+	c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+}
+
+func (c *Compiler) currentLoop() *LoopContext {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+// Blocks get their own names but share the slots of the enclosing scope
+func (c *Compiler) enterBlock() {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveBlock() {
+	c.symbolTable = c.symbolTable.LeaveBlock()
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
+
+// define declares a variable in the current scope. Constants of the same
+// scope can't be declared again.
+func (c *Compiler) define(name *ast.Identifier, constant bool) (Symbol, error) {
+	if c.symbolTable.IsConstant(name.Value) {
+		return Symbol{}, fmt.Errorf("%s: cannot assign to constant %s", name.Pos(), name.Value)
+	}
+
+	if constant {
+		return c.symbolTable.DefineConst(name.Value), nil
+	}
+	return c.symbolTable.Define(name.Value), nil
+}
+
+// storeSymbol pops the top of the stack into a variable defined with let.
+// A local gets a new binding, closures created by an earlier run of the same
+// code keep the old one.
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpDefineLocal, s.Index)
+	}
+}
+
+// captureSymbol pushes the cell of a variable a closure captures, so the
+// closure shares it with the scope defining it
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
 	}
 }
@@ -0,0 +1,530 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"monkey/code"
+	"monkey/object"
+	"monkey/token"
+)
+
+// Compiled programs are stored in .wfc files as
+//
+//	magic            "WAFC"
+//	version          uint16
+//	instruction set  uint32, CRC-32 of the opcode and builtin tables
+//	checksum         uint32, CRC-32 of the payload
+//	payload          main frame locals, instructions, positions and constants
+//
+// All integers are big endian. Strings and instructions are prefixed with
+// their length, lists with their number of entries.
+//
+// BytecodeVersion is bumped whenever the layout of the file changes. Adding
+// or changing an opcode or builtin doesn't need a bump, it changes the
+// instruction set checksum, so files compiled before are rejected anyway.
+const (
+	BytecodeMagic   = "WAFC"
+	BytecodeVersion = 1
+)
+
+const headerSize = len(BytecodeMagic) + 2 + 4 + 4
+
+// instructionSet is the checksum of the opcode and builtin tables that
+// compiled files are checked against.
+var instructionSet = instructionSetChecksum()
+
+func instructionSetChecksum() uint32 {
+	h := crc32.NewIEEE()
+	for op := 0; op <= math.MaxUint8; op++ {
+		def, err := code.Lookup(byte(op))
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(h, "%d %s %v\n", op, def.Name, def.OperandWidths)
+	}
+	for _, def := range object.Builtins {
+		fmt.Fprintf(h, "%s\n", def.Name)
+	}
+	return h.Sum32()
+}
+
+// Tags of the constant kinds in the constant pool
+const (
+	constInteger byte = iota + 1
+	constFloat
+	constString
+	constFunction
+)
+
+var ErrNotBytecode = errors.New("not a waffle bytecode file")
+
+// Encode serializes the bytecode in the .wfc format.
+func Encode(bytecode *Bytecode) ([]byte, error) {
+	e := &encoder{}
+
+	e.writeUint32(bytecode.NumLocals)
+	e.writeInstructions(bytecode.Instructions)
+	e.writePositions(bytecode.Positions)
+
+	e.writeUint32(len(bytecode.Constants))
+	for _, constant := range bytecode.Constants {
+		err := e.writeConstant(constant)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	payload := e.buf.Bytes()
+
+	out := make([]byte, headerSize, headerSize+len(payload))
+	copy(out, BytecodeMagic)
+	binary.BigEndian.PutUint16(out[4:], BytecodeVersion)
+	binary.BigEndian.PutUint32(out[6:], instructionSet)
+	binary.BigEndian.PutUint32(out[10:], crc32.ChecksumIEEE(payload))
+
+	return append(out, payload...), nil
+}
+
+// Decode reads bytecode written by Encode. Files from another version of the
+// format or instruction set, with a wrong checksum or with instructions the
+// VM can't run are rejected.
+func Decode(data []byte) (*Bytecode, error) {
+	if len(data) < headerSize || string(data[:4]) != BytecodeMagic {
+		return nil, ErrNotBytecode
+	}
+
+	version := binary.BigEndian.Uint16(data[4:])
+	if version != BytecodeVersion {
+		return nil, fmt.Errorf("unsupported bytecode version %d, want %d", version, BytecodeVersion)
+	}
+
+	if binary.BigEndian.Uint32(data[6:]) != instructionSet {
+		return nil, errors.New("bytecode was compiled for different opcodes or builtins, compile it again")
+	}
+
+	payload := data[headerSize:]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[10:]) {
+		return nil, errors.New("bytecode checksum mismatch, the file is corrupted")
+	}
+
+	d := &decoder{data: payload}
+	bytecode := &Bytecode{
+		NumLocals:    d.readUint32(),
+		Instructions: d.readInstructions(),
+		Positions:    d.readPositions(),
+	}
+
+	n := d.readLength()
+	for i := 0; i < n && d.err == nil; i++ {
+		bytecode.Constants = append(bytecode.Constants, d.readConstant())
+	}
+
+	if d.err == nil && d.offset != len(d.data) {
+		d.err = fmt.Errorf("%d unexpected bytes after the constants", len(d.data)-d.offset)
+	}
+	if d.err == nil {
+		d.err = validate(bytecode)
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("invalid bytecode: %w", d.err)
+	}
+
+	return bytecode, nil
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) writeUint32(n int) {
+	binary.Write(&e.buf, binary.BigEndian, uint32(n))
+}
+
+func (e *encoder) writeUint64(n uint64) {
+	binary.Write(&e.buf, binary.BigEndian, n)
+}
+
+func (e *encoder) writeString(s string) {
+	e.writeUint32(len(s))
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) writeInstructions(ins code.Instructions) {
+	e.writeUint32(len(ins))
+	e.buf.Write(ins)
+}
+
+func (e *encoder) writePositions(positions code.Positions) {
+	e.writeUint32(len(positions))
+	for _, p := range positions {
+		e.writeUint32(p.Offset)
+		e.writeString(p.Pos.File)
+		e.writeUint32(p.Pos.Line)
+		e.writeUint32(p.Pos.Column)
+	}
+}
+
+func (e *encoder) writeConstant(constant object.Object) error {
+	switch constant := constant.(type) {
+	case *object.Integer:
+		e.buf.WriteByte(constInteger)
+		e.writeUint64(uint64(constant.Value))
+
+	case *object.Float:
+		e.buf.WriteByte(constFloat)
+		e.writeUint64(math.Float64bits(constant.Value))
+
+	case *object.String:
+		e.buf.WriteByte(constString)
+		e.writeString(constant.Value)
+
+	case *object.CompiledFunction:
+		e.buf.WriteByte(constFunction)
+		e.writeInstructions(constant.Instructions)
+		e.writeUint32(constant.NumLocals)
+		e.writeUint32(constant.NumParameters)
+		e.writeString(constant.Name)
+		e.writePositions(constant.Positions)
+
+	default:
+		return fmt.Errorf("cannot encode constant of type %s", constant.Type())
+	}
+
+	return nil
+}
+
+// decoder reads the payload. The first error is kept and every read after
+// it returns zero values, so callers only check err once they're done.
+type decoder struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (d *decoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.data)-d.offset {
+		d.err = errors.New("unexpected end of data")
+		return nil
+	}
+
+	b := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return b
+}
+
+func (d *decoder) readByte() byte {
+	b := d.read(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) readUint32() int {
+	b := d.read(4)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) readUint64() uint64 {
+	b := d.read(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// readLength reads the size of a list or string, which can't be larger
+// than what is left of the data
+func (d *decoder) readLength() int {
+	n := d.readUint32()
+	if d.err == nil && n > len(d.data)-d.offset {
+		d.err = fmt.Errorf("length %d exceeds the remaining data", n)
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) readString() string {
+	return string(d.read(d.readLength()))
+}
+
+func (d *decoder) readInstructions() code.Instructions {
+	ins := d.read(d.readLength())
+	return append(code.Instructions{}, ins...)
+}
+
+func (d *decoder) readPositions() code.Positions {
+	n := d.readLength()
+
+	positions := code.Positions{}
+	for i := 0; i < n && d.err == nil; i++ {
+		offset := d.readUint32()
+		pos := token.Position{File: d.readString()}
+		pos.Line = d.readUint32()
+		pos.Column = d.readUint32()
+
+		positions = append(positions, code.SourcePosition{Offset: offset, Pos: pos})
+	}
+	return positions
+}
+
+func (d *decoder) readConstant() object.Object {
+	switch tag := d.readByte(); tag {
+	case constInteger:
+		return &object.Integer{Value: int64(d.readUint64())}
+
+	case constFloat:
+		return &object.Float{Value: math.Float64frombits(d.readUint64())}
+
+	case constString:
+		return &object.String{Value: d.readString()}
+
+	case constFunction:
+		fn := &object.CompiledFunction{Instructions: d.readInstructions()}
+		fn.NumLocals = d.readUint32()
+		fn.NumParameters = d.readUint32()
+		fn.Name = d.readString()
+		fn.Positions = d.readPositions()
+		return fn
+
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown constant tag %d", tag)
+		}
+		return nil
+	}
+}
+
+// validate checks that the instructions of the program and its functions
+// only use opcodes, constants, builtins, variables and jump targets that
+// exist, and never pop more values than the stack of their frame holds. The
+// types of the values are left to the VM, which checks them as it runs.
+func validate(bytecode *Bytecode) error {
+	// The free variables of a function are given by the OpClosure
+	// instructions creating it
+	numFree := map[int]int{}
+
+	check := func(name string, ins code.Instructions, numLocals int) error {
+		err := walkInstructions(name, ins, func(op code.Opcode, operands []int) error {
+			switch op {
+			case code.OpConstant:
+				if operands[0] >= len(bytecode.Constants) {
+					return fmt.Errorf("constant %d doesn't exist", operands[0])
+				}
+
+			case code.OpClosure:
+				if operands[0] >= len(bytecode.Constants) {
+					return fmt.Errorf("constant %d doesn't exist", operands[0])
+				}
+				if _, ok := bytecode.Constants[operands[0]].(*object.CompiledFunction); !ok {
+					return fmt.Errorf("constant %d is not a function", operands[0])
+				}
+				if n, ok := numFree[operands[0]]; ok && n != operands[1] {
+					return fmt.Errorf("function %d is created with %d and %d free variables", operands[0], n, operands[1])
+				}
+				numFree[operands[0]] = operands[1]
+
+			case code.OpGetBuiltin:
+				if operands[0] >= len(object.Builtins) {
+					return fmt.Errorf("builtin %d doesn't exist", operands[0])
+				}
+
+			case code.OpGetLocal, code.OpSetLocal, code.OpDefineLocal, code.OpCaptureLocal:
+				if operands[0] >= numLocals {
+					return fmt.Errorf("local %d doesn't exist, there are %d", operands[0], numLocals)
+				}
+
+			case code.OpJump, code.OpJumpNotTruthy, code.OpSetupTry:
+				if !isInstructionStart(ins, operands[0]) {
+					return fmt.Errorf("jump target %d is not an instruction", operands[0])
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return checkStack(name, ins)
+	}
+
+	if bytecode.NumLocals > maxLocals {
+		return fmt.Errorf("main program: %d locals, at most %d are allowed", bytecode.NumLocals, maxLocals)
+	}
+	err := check("main program", bytecode.Instructions, bytecode.NumLocals)
+	if err != nil {
+		return err
+	}
+
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		name := fmt.Sprintf("function %d", i)
+		if fn.NumLocals > maxLocals {
+			return fmt.Errorf("%s: %d locals, at most %d are allowed", name, fn.NumLocals, maxLocals)
+		}
+		if fn.NumParameters > fn.NumLocals {
+			return fmt.Errorf("%s: %d parameters but %d locals", name, fn.NumParameters, fn.NumLocals)
+		}
+		err := check(name, fn.Instructions, fn.NumLocals)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Free variables are checked once every OpClosure has been seen
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		err := walkInstructions(fmt.Sprintf("function %d", i), fn.Instructions, func(op code.Opcode, operands []int) error {
+			switch op {
+			case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+				if operands[0] >= numFree[i] {
+					return fmt.Errorf("free variable %d doesn't exist, there are %d", operands[0], numFree[i])
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkInstructions calls visit with every instruction of ins, failing on
+// undefined opcodes and missing operands. Errors are prefixed with name and
+// the offset of the instruction.
+func walkInstructions(name string, ins code.Instructions, visit func(op code.Opcode, operands []int) error) error {
+	for offset := 0; offset < len(ins); {
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			return fmt.Errorf("%s at %04d: %w", name, offset, err)
+		}
+		if offset+def.Width() > len(ins) {
+			return fmt.Errorf("%s at %04d: %s is missing operands", name, offset, def.Name)
+		}
+
+		operands, read := code.ReadOperands(def, ins[offset+1:])
+		err = visit(code.Opcode(ins[offset]), operands)
+		if err != nil {
+			return fmt.Errorf("%s at %04d: %w", name, offset, err)
+		}
+
+		offset += 1 + read
+	}
+	return nil
+}
+
+// isInstructionStart reports whether target is the offset of an instruction
+// of ins or the end of ins
+func isInstructionStart(ins code.Instructions, target int) bool {
+	offset := 0
+	for offset < target && offset < len(ins) {
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			return false
+		}
+		offset += def.Width()
+	}
+	return offset == target
+}
+
+// checkStack follows every path through ins, which must be valid otherwise,
+// and fails if an instruction pops more values than the stack holds. Only
+// the lowest depth seen at an instruction is followed, so loops end.
+func checkStack(name string, ins code.Instructions) error {
+	depths := map[int]int{}
+	work := []int{}
+
+	reach := func(offset, depth int) {
+		if offset >= len(ins) {
+			return
+		}
+		if d, ok := depths[offset]; !ok || depth < d {
+			depths[offset] = depth
+			work = append(work, offset)
+		}
+	}
+	reach(0, 0)
+
+	for len(work) > 0 {
+		offset := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		def, _ := code.Lookup(ins[offset])
+		operands, read := code.ReadOperands(def, ins[offset+1:])
+		op := code.Opcode(ins[offset])
+
+		pops, pushes := stackEffect(op, operands)
+		depth := depths[offset]
+		if pops > depth {
+			return fmt.Errorf("%s at %04d: %s pops %d values, the stack holds %d", name, offset, def.Name, pops, depth)
+		}
+		depth += pushes - pops
+
+		next := offset + 1 + read
+		switch op {
+		case code.OpJump:
+			reach(operands[0], depth)
+		case code.OpJumpNotTruthy:
+			reach(next, depth)
+			reach(operands[0], depth)
+		case code.OpSetupTry:
+			// The handler starts with the error pushed onto the stack as
+			// it was when the try began
+			reach(next, depth)
+			reach(operands[0], depth+1)
+		case code.OpReturnValue, code.OpReturn, code.OpThrow:
+		default:
+			reach(next, depth)
+		}
+	}
+
+	return nil
+}
+
+// stackEffect returns how many values an instruction pops and pushes
+func stackEffect(op code.Opcode, operands []int) (int, int) {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetGlobal,
+		code.OpGetLocal, code.OpCaptureLocal, code.OpGetFree, code.OpCaptureFree,
+		code.OpCurrentClosure, code.OpGetBuiltin:
+		return 0, 1
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpEqual,
+		code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanEqual, code.OpLessThan, code.OpLessThanEqual, code.OpIndex:
+		return 2, 1
+	case code.OpMinus, code.OpBang, code.OpIter, code.OpIterNext, code.OpCatch:
+		return 1, 1
+	case code.OpPop, code.OpJumpNotTruthy, code.OpSetGlobal, code.OpSetLocal,
+		code.OpDefineLocal, code.OpSetFree, code.OpReturnValue, code.OpThrow:
+		return 1, 0
+	case code.OpArray, code.OpHash:
+		return operands[0], 1
+	case code.OpCall:
+		return operands[0] + 1, 1
+	case code.OpClosure:
+		return operands[1], 1
+	case code.OpSetIndex:
+		return 3, 1
+	case code.OpIterValues:
+		return 1, operands[0]
+	}
+	return 0, 0
+}
@@ -0,0 +1,241 @@
+package compiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"monkey/code"
+	"monkey/object"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	input := `
+	let add = fn(a, b) { a + b };
+	let scale = fn(x) { let f = 2.5; fn(y) { add(x, y) * f } };
+	puts(scale(1)(2), "done", -9223372036854775807);
+	for (i in [1]) { puts(i) };
+	`
+
+	program := parse(input)
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	data, err := Encode(bytecode)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	if !strings.HasPrefix(string(data), BytecodeMagic) {
+		t.Errorf("data does not start with the magic header. got=%q", data[:4])
+	}
+
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	// The loop's iterator and i
+	if decoded.NumLocals != 2 {
+		t.Errorf("wrong number of main frame locals. want=2, got=%d", decoded.NumLocals)
+	}
+	if !reflect.DeepEqual(decoded, bytecode) {
+		t.Errorf("decoded bytecode differs.\nwant=%+v\ngot=%+v", bytecode, decoded)
+	}
+}
+
+func TestEncodeUnsupportedConstant(t *testing.T) {
+	bytecode := &Bytecode{Constants: []object.Object{&object.Array{}}}
+
+	_, err := Encode(bytecode)
+	if err == nil || err.Error() != "cannot encode constant of type ARRAY" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid, err := Encode(&Bytecode{
+		Instructions: []byte{1, 2, 3},
+		Constants:    []object.Object{&object.String{Value: "hello"}},
+	})
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	modified := func(change func(data []byte) []byte) []byte {
+		data := append([]byte{}, valid...)
+		return change(data)
+	}
+
+	// Damaged payloads with a matching checksum get past the header check
+	withChecksum := func(data []byte) []byte {
+		binary.BigEndian.PutUint32(data[10:], crc32.ChecksumIEEE(data[headerSize:]))
+		return data
+	}
+
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte("let a = 1;"), "not a waffle bytecode file"},
+		{[]byte("WAF"), "not a waffle bytecode file"},
+		{
+			modified(func(data []byte) []byte { data[5] = 99; return data }),
+			fmt.Sprintf("unsupported bytecode version 99, want %d", BytecodeVersion),
+		},
+		{
+			modified(func(data []byte) []byte { data[9] ^= 1; return data }),
+			"bytecode was compiled for different opcodes or builtins, compile it again",
+		},
+		{
+			modified(func(data []byte) []byte { data[len(data)-1] = 'x'; return data }),
+			"bytecode checksum mismatch, the file is corrupted",
+		},
+		{
+			modified(func(data []byte) []byte { return withChecksum(data[:len(data)-2]) }),
+			"invalid bytecode: length 5 exceeds the remaining data",
+		},
+		{
+			modified(func(data []byte) []byte { return withChecksum(append(data, 0)) }),
+			"invalid bytecode: 1 unexpected bytes after the constants",
+		},
+		{
+			modified(func(data []byte) []byte { data[len(data)-10] = 42; return withChecksum(data) }),
+			"invalid bytecode: unknown constant tag 42",
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(tt.data)
+		if err == nil {
+			t.Errorf("expected error %q, got none", tt.expected)
+			continue
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestDecodeInvalidInstructions(t *testing.T) {
+	instructions := func(ins ...[]byte) code.Instructions {
+		out := code.Instructions{}
+		for _, i := range ins {
+			out = append(out, i...)
+		}
+		return out
+	}
+	function := func(numLocals int, ins ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: instructions(ins...), NumLocals: numLocals}
+	}
+
+	tests := []struct {
+		bytecode *Bytecode
+		expected string
+	}{
+		{
+			&Bytecode{Instructions: instructions([]byte{255})},
+			"main program at 0000: opcode 255 undefined",
+		},
+		{
+			&Bytecode{Instructions: instructions(code.Make(code.OpPop), []byte{byte(code.OpConstant), 0})},
+			"main program at 0001: OpConstant is missing operands",
+		},
+		{
+			&Bytecode{Instructions: instructions(code.Make(code.OpConstant, 1)), Constants: []object.Object{&object.Integer{}}},
+			"main program at 0000: constant 1 doesn't exist",
+		},
+		{
+			&Bytecode{Instructions: instructions(code.Make(code.OpGetBuiltin, 200))},
+			"main program at 0000: builtin 200 doesn't exist",
+		},
+		{
+			&Bytecode{Instructions: instructions(code.Make(code.OpDefineLocal, 2)), NumLocals: 2},
+			"main program at 0000: local 2 doesn't exist, there are 2",
+		},
+		{
+			&Bytecode{Instructions: instructions(code.Make(code.OpJump, 1))},
+			"main program at 0000: jump target 1 is not an instruction",
+		},
+		{
+			&Bytecode{Instructions: instructions(code.Make(code.OpJump, 4))},
+			"main program at 0000: jump target 4 is not an instruction",
+		},
+		{
+			&Bytecode{Instructions: instructions(code.Make(code.OpClosure, 0, 0)), Constants: []object.Object{&object.Integer{}}},
+			"main program at 0000: constant 0 is not a function",
+		},
+		{
+			&Bytecode{
+				Instructions: instructions(code.Make(code.OpClosure, 0, 1), code.Make(code.OpClosure, 0, 2)),
+				Constants:    []object.Object{function(0, code.Make(code.OpReturn))},
+			},
+			"main program at 0004: function 0 is created with 1 and 2 free variables",
+		},
+		{
+			&Bytecode{
+				Instructions: instructions(code.Make(code.OpNull), code.Make(code.OpClosure, 0, 1)),
+				Constants:    []object.Object{function(0, code.Make(code.OpGetFree, 0), code.Make(code.OpGetFree, 1))},
+			},
+			"function 0 at 0002: free variable 1 doesn't exist, there are 1",
+		},
+		{
+			&Bytecode{Instructions: instructions(code.Make(code.OpPop))},
+			"main program at 0000: OpPop pops 1 values, the stack holds 0",
+		},
+		{
+			&Bytecode{Instructions: instructions(
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 5),
+				code.Make(code.OpNull),
+				code.Make(code.OpAdd),
+			)},
+			"main program at 0005: OpAdd pops 2 values, the stack holds 0",
+		},
+		{
+			&Bytecode{
+				Instructions: instructions(code.Make(code.OpClosure, 0, 0)),
+				Constants:    []object.Object{function(1, code.Make(code.OpGetLocal, 0), code.Make(code.OpCall, 1))},
+			},
+			"function 0 at 0002: OpCall pops 2 values, the stack holds 1",
+		},
+		{
+			&Bytecode{Constants: []object.Object{function(300)}},
+			"function 0: 300 locals, at most 256 are allowed",
+		},
+		{
+			&Bytecode{NumLocals: 300},
+			"main program: 300 locals, at most 256 are allowed",
+		},
+	}
+
+	for _, tt := range tests {
+		data, err := Encode(tt.bytecode)
+		if err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+
+		_, err = Decode(data)
+		if err == nil {
+			t.Errorf("expected error %q, got none", tt.expected)
+			continue
+		}
+
+		if err.Error() != "invalid bytecode: "+tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", "invalid bytecode: "+tt.expected, err)
+		}
+	}
+
+	// Jumping to the end of the instructions is fine
+	data, _ := Encode(&Bytecode{Instructions: instructions(code.Make(code.OpJump, 3))})
+	if _, err := Decode(data); err != nil {
+		t.Errorf("decode error: %s", err)
+	}
+}
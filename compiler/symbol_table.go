@@ -1,35 +1,213 @@
 package compiler
 
+import "sort"
+
 type SymbolScope string
 
 const (
-	GlobalScope SymbolScope = "GLOBAL"
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
 )
 
 type Symbol struct {
-	Name  string
-	Scope SymbolScope
-	Index int
+	Name     string
+	Scope    SymbolScope
+	Index    int
+	Constant bool
 }
 
 type SymbolTable struct {
+	Outer *SymbolTable
+
 	store          map[string]Symbol
 	numDefinitions int
+
+	// Block tables have no slots of their own, their symbols are numbered
+	// by the enclosing function's table. Blocks of the main program keep
+	// their variables in the main frame as locals numbered by the global
+	// table, so that closures get a new variable on every run of the block
+	// too. Those slots are reused once the block ends.
+	block bool
+
+	// Main frame slots of the global table in use and the most in use at
+	// once. A block remembers how many were in use when it began.
+	mainLocals    int
+	numMainLocals int
+
+	// Symbols of enclosing scopes captured by this scope, in the order
+	// they are pushed when the closure is created
+	FreeSymbols []Symbol
 }
 
 func NewSymbolTable() *SymbolTable {
 	s := make(map[string]Symbol)
-	return &SymbolTable{store: s}
+	free := []Symbol{}
+	return &SymbolTable{store: s, FreeSymbols: free}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// Copy returns a copy of s to define names in without changing s. The
+// enclosing tables are shared.
+func (s *SymbolTable) Copy() *SymbolTable {
+	c := *s
+	c.store = make(map[string]Symbol, len(s.store))
+	for name, symbol := range s.store {
+		c.store[name] = symbol
+	}
+	c.FreeSymbols = append([]Symbol{}, s.FreeSymbols...)
+	return &c
+}
+
+// NewBlockSymbolTable returns the table of a block nested in outer. Its
+// names shadow the outer ones but live in the same frame, so unlike a
+// function's table it never captures free variables.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewEnclosedSymbolTable(outer)
+	s.block = true
+	s.mainLocals = s.slots().mainLocals
+	return s
+}
+
+// LeaveBlock returns the table enclosing the block s, freeing the main frame
+// slots of its variables.
+func (s *SymbolTable) LeaveBlock() *SymbolTable {
+	if owner := s.slots(); owner.Outer == nil {
+		owner.mainLocals = s.mainLocals
+	}
+	return s.Outer
+}
+
+// slots returns the table that numbers the definitions made in s
+func (s *SymbolTable) slots() *SymbolTable {
+	for s.block {
+		s = s.Outer
+	}
+	return s
 }
 
 func (s *SymbolTable) Define(name string) Symbol {
-	symbol := Symbol{Name: name, Index: s.numDefinitions, Scope: GlobalScope}
+	// Redeclaring a name in the same scope reuses its slot, so that code
+	// already referring to it (e.g. a loop condition) sees the new value
+	if s.isDefined(name) {
+		return s.store[name]
+	}
+
+	owner := s.slots()
+	symbol := Symbol{Name: name}
+
+	switch {
+	case owner.Outer != nil:
+		symbol.Scope = LocalScope
+		symbol.Index = owner.numDefinitions
+		owner.numDefinitions++
+
+	case s.block:
+		symbol.Scope = LocalScope
+		symbol.Index = owner.mainLocals
+		owner.mainLocals++
+		if owner.mainLocals > owner.numMainLocals {
+			owner.numMainLocals = owner.mainLocals
+		}
+
+	default:
+		symbol.Scope = GlobalScope
+		symbol.Index = owner.numDefinitions
+		owner.numDefinitions++
+	}
+
 	s.store[name] = symbol
-	s.numDefinitions++
 	return symbol
 }
 
+// isDefined reports whether name is a variable of this scope, which Define
+// would reuse
+func (s *SymbolTable) isDefined(name string) bool {
+	existing, ok := s.store[name]
+	return ok && (existing.Scope == GlobalScope || existing.Scope == LocalScope)
+}
+
+// DefineConst defines name like Define and marks it as a constant, which
+// can't be assigned to or redefined in the same scope.
+func (s *SymbolTable) DefineConst(name string) Symbol {
+	symbol := s.Define(name)
+	symbol.Constant = true
+	s.store[name] = symbol
+	return symbol
+}
+
+// IsConstant reports whether name is a constant defined in this scope.
+func (s *SymbolTable) IsConstant(name string) bool {
+	return s.store[name].Constant
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope, Constant: original.Constant}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+// Symbols returns the symbols defined in this table, sorted by name.
+func (s *SymbolTable) Symbols() []Symbol {
+	symbols := make([]Symbol, 0, len(s.store))
+	for _, symbol := range s.store {
+		symbols = append(symbols, symbol)
+	}
+
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Name < symbols[j].Name
+	})
+	return symbols
+}
+
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	return s.resolve(name, false)
+}
+
+// ResolveVariable resolves name like Resolve, except that the name of the
+// function being compiled resolves to the variable holding the function, so
+// that it can be assigned to
+func (s *SymbolTable) ResolveVariable(name string) (Symbol, bool) {
+	return s.resolve(name, true)
+}
+
+func (s *SymbolTable) resolve(name string, variable bool) (Symbol, bool) {
 	obj, ok := s.store[name]
+	if ok && variable && obj.Scope == FunctionScope {
+		ok = false
+	}
+	if !ok && s.Outer != nil {
+		obj, ok = s.Outer.resolve(name, variable)
+		if !ok || s.block {
+			return obj, ok
+		}
+
+		if obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+			return obj, ok
+		}
+
+		return s.defineFree(obj), true
+	}
 	return obj, ok
 }
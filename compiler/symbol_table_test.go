@@ -43,3 +43,352 @@ func TestResolveGlobal(t *testing.T) {
 		}
 	}
 }
+
+func TestResolveLocal(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("c")
+	local.Define("d")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: GlobalScope, Index: 1},
+		{Name: "c", Scope: LocalScope, Index: 0},
+		{Name: "d", Scope: LocalScope, Index: 1},
+	}
+
+	for _, sym := range expected {
+		result, ok := local.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+}
+
+func TestResolveNestedLocal(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("b")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("c")
+
+	tests := []struct {
+		table           *SymbolTable
+		expectedSymbols []Symbol
+	}{
+		{
+			firstLocal,
+			[]Symbol{
+				{Name: "a", Scope: GlobalScope, Index: 0},
+				{Name: "b", Scope: LocalScope, Index: 0},
+			},
+		},
+		{
+			secondLocal,
+			[]Symbol{
+				{Name: "a", Scope: GlobalScope, Index: 0},
+				{Name: "c", Scope: LocalScope, Index: 0},
+				{Name: "b", Scope: FreeScope, Index: 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, sym := range tt.expectedSymbols {
+			result, ok := tt.table.Resolve(sym.Name)
+			if !ok {
+				t.Errorf("name %s not resolvable", sym.Name)
+				continue
+			}
+
+			if result != sym {
+				t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+			}
+		}
+	}
+}
+
+func TestDefineResolveBuiltins(t *testing.T) {
+	global := NewSymbolTable()
+	firstLocal := NewEnclosedSymbolTable(global)
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+
+	expected := []Symbol{
+		{Name: "a", Scope: BuiltinScope, Index: 0},
+		{Name: "c", Scope: BuiltinScope, Index: 1},
+		{Name: "e", Scope: BuiltinScope, Index: 2},
+		{Name: "f", Scope: BuiltinScope, Index: 3},
+	}
+
+	for i, v := range expected {
+		global.DefineBuiltin(i, v.Name)
+	}
+
+	for _, table := range []*SymbolTable{global, firstLocal, secondLocal} {
+		for _, sym := range expected {
+			result, ok := table.Resolve(sym.Name)
+			if !ok {
+				t.Errorf("name %s not resolvable", sym.Name)
+				continue
+			}
+			if result != sym {
+				t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+			}
+		}
+	}
+}
+
+func TestResolveFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("c")
+	firstLocal.Define("d")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("e")
+	secondLocal.Define("f")
+
+	tests := []struct {
+		table               *SymbolTable
+		expectedSymbols     []Symbol
+		expectedFreeSymbols []Symbol
+	}{
+		{
+			firstLocal,
+			[]Symbol{
+				{Name: "a", Scope: GlobalScope, Index: 0},
+				{Name: "b", Scope: GlobalScope, Index: 1},
+				{Name: "c", Scope: LocalScope, Index: 0},
+				{Name: "d", Scope: LocalScope, Index: 1},
+			},
+			[]Symbol{},
+		},
+		{
+			secondLocal,
+			[]Symbol{
+				{Name: "a", Scope: GlobalScope, Index: 0},
+				{Name: "b", Scope: GlobalScope, Index: 1},
+				{Name: "c", Scope: FreeScope, Index: 0},
+				{Name: "d", Scope: FreeScope, Index: 1},
+				{Name: "e", Scope: LocalScope, Index: 0},
+				{Name: "f", Scope: LocalScope, Index: 1},
+			},
+			[]Symbol{
+				{Name: "c", Scope: LocalScope, Index: 0},
+				{Name: "d", Scope: LocalScope, Index: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, sym := range tt.expectedSymbols {
+			result, ok := tt.table.Resolve(sym.Name)
+			if !ok {
+				t.Errorf("name %s not resolvable", sym.Name)
+				continue
+			}
+			if result != sym {
+				t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+			}
+		}
+
+		if len(tt.table.FreeSymbols) != len(tt.expectedFreeSymbols) {
+			t.Errorf("wrong number of free symbols. got=%d, want=%d", len(tt.table.FreeSymbols), len(tt.expectedFreeSymbols))
+			continue
+		}
+
+		for i, sym := range tt.expectedFreeSymbols {
+			result := tt.table.FreeSymbols[i]
+			if result != sym {
+				t.Errorf("wrong free symbol. got=%+v, want=%+v", result, sym)
+			}
+		}
+	}
+}
+
+func TestDefineAndResolveFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineFunctionName("a")
+
+	expected := Symbol{Name: "a", Scope: FunctionScope, Index: 0}
+
+	result, ok := global.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v", expected.Name, expected, result)
+	}
+}
+
+func TestRedefine(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	a := global.Define("a")
+	expected := Symbol{Name: "a", Scope: GlobalScope, Index: 0}
+	if a != expected {
+		t.Errorf("expected redefined a=%+v, got=%+v", expected, a)
+	}
+
+	c := global.Define("c")
+	expected = Symbol{Name: "c", Scope: GlobalScope, Index: 2}
+	if c != expected {
+		t.Errorf("expected c=%+v, got=%+v", expected, c)
+	}
+}
+
+func TestDefineConst(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.DefineConst("a")
+	expected := Symbol{Name: "a", Scope: GlobalScope, Index: 0, Constant: true}
+	if a != expected {
+		t.Errorf("expected a=%+v, got=%+v", expected, a)
+	}
+
+	if !global.IsConstant("a") {
+		t.Errorf("a is not a constant")
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	if local.IsConstant("a") {
+		t.Errorf("a is a constant of the local scope")
+	}
+
+	b := local.DefineConst("b")
+	inner := NewEnclosedSymbolTable(local)
+	result, ok := inner.Resolve("b")
+	if !ok {
+		t.Fatalf("name b not resolvable")
+	}
+
+	expected = Symbol{Name: "b", Scope: FreeScope, Index: 0, Constant: true}
+	if result != expected {
+		t.Errorf("expected b to resolve to %+v, got=%+v", expected, result)
+	}
+
+	if !b.Constant {
+		t.Errorf("b is not a constant")
+	}
+}
+
+func TestBlockSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	block := NewBlockSymbolTable(global)
+	block.Define("a")
+	block.Define("b")
+
+	// Block variables of the main program are locals of its frame
+	expected := []Symbol{
+		{Name: "a", Scope: LocalScope, Index: 0},
+		{Name: "b", Scope: LocalScope, Index: 1},
+	}
+	for _, sym := range expected {
+		result, ok := block.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	// Leaving the block makes the outer a visible again and frees its slots
+	if block.LeaveBlock() != global {
+		t.Errorf("leaving the block doesn't return to the global table")
+	}
+	result, _ := global.Resolve("a")
+	if result != (Symbol{Name: "a", Scope: GlobalScope, Index: 0}) {
+		t.Errorf("expected global a, got=%+v", result)
+	}
+	if _, ok := global.Resolve("b"); ok {
+		t.Errorf("b is resolvable outside of its block")
+	}
+
+	next := NewBlockSymbolTable(global)
+	if c := next.Define("c"); c != (Symbol{Name: "c", Scope: LocalScope, Index: 0}) {
+		t.Errorf("expected c to reuse the first slot, got=%+v", c)
+	}
+	next.LeaveBlock()
+	if global.numMainLocals != 2 {
+		t.Errorf("wrong number of main frame locals. want=2, got=%d", global.numMainLocals)
+	}
+
+	block = NewBlockSymbolTable(global)
+	block.Define("a")
+	local := NewEnclosedSymbolTable(block)
+	local.Define("c")
+	localBlock := NewBlockSymbolTable(local)
+	localBlock.Define("d")
+
+	// Block variables of a function are locals of its frame, and names of
+	// enclosing blocks of the same function are not free
+	expected = []Symbol{
+		{Name: "a", Scope: FreeScope, Index: 0},
+		{Name: "c", Scope: LocalScope, Index: 0},
+		{Name: "d", Scope: LocalScope, Index: 1},
+	}
+	for _, sym := range expected {
+		result, ok := localBlock.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	if local.numDefinitions != 2 {
+		t.Errorf("wrong number of locals. want=2, got=%d", local.numDefinitions)
+	}
+	if len(local.FreeSymbols) != 1 {
+		t.Errorf("the block variable a should be captured. got=%+v", local.FreeSymbols)
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("b")
+	global.DefineBuiltin(0, "len")
+	global.DefineConst("a")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("c")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 1, Constant: true},
+		{Name: "b", Scope: GlobalScope, Index: 0},
+		{Name: "len", Scope: BuiltinScope, Index: 0},
+	}
+
+	symbols := global.Symbols()
+	if len(symbols) != len(expected) {
+		t.Fatalf("wrong number of symbols. want=%d, got=%d", len(expected), len(symbols))
+	}
+
+	for i, sym := range expected {
+		if symbols[i] != sym {
+			t.Errorf("expected symbols[%d]=%+v, got=%+v", i, sym, symbols[i])
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Package disasm prints compiled programs in a readable form.
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/object"
+	"strconv"
+	"strings"
+)
+
+// Disassembler prints the main program, the constant pool and every
+// compiled function of a program.
+type Disassembler struct {
+	bytecode *compiler.Bytecode
+	globals  map[int]string
+	lines    []string
+
+	// index of the first constant compiled from the source
+	first int
+}
+
+// New returns a disassembler for bytecode. The symbol table and source are
+// optional, without them globals are shown by index and source lines by
+// number only. Only globals are named, the bytecode doesn't keep the names
+// of locals and free variables, so those are always shown by index.
+func New(bytecode *compiler.Bytecode, symbols *compiler.SymbolTable, source string) *Disassembler {
+	d := &Disassembler{bytecode: bytecode, globals: map[int]string{}}
+
+	if symbols != nil {
+		for _, symbol := range symbols.Symbols() {
+			if symbol.Scope == compiler.GlobalScope {
+				d.globals[symbol.Index] = symbol.Name
+			}
+		}
+	}
+
+	if source != "" {
+		d.lines = strings.Split(source, "\n")
+	}
+
+	return d
+}
+
+// SkipConstants leaves out the first n constants, which were compiled from
+// earlier code (e.g. previous REPL inputs) that the source doesn't contain.
+// Instructions referring to them are still annotated.
+func (d *Disassembler) SkipConstants(n int) {
+	d.first = n
+}
+
+func (d *Disassembler) Disassemble(out io.Writer) {
+	fmt.Fprintln(out, "== main ==")
+	d.instructions(out, d.bytecode.Instructions, d.bytecode.Positions)
+
+	if len(d.bytecode.Constants) <= d.first {
+		return
+	}
+
+	fmt.Fprintln(out, "\n== constants ==")
+	for i := d.first; i < len(d.bytecode.Constants); i++ {
+		fmt.Fprintf(out, "%4d %s\n", i, describe(d.bytecode.Constants[i]))
+	}
+
+	for i := d.first; i < len(d.bytecode.Constants); i++ {
+		fn, ok := d.bytecode.Constants[i].(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		fmt.Fprintf(out, "\n== %s (constant %d, %d params, %d locals) ==\n",
+			functionName(fn), i, fn.NumParameters, fn.NumLocals)
+		d.instructions(out, fn.Instructions, fn.Positions)
+	}
+}
+
+// instructions prints one instruction per line, preceded by the source line
+// it was compiled from whenever that line changes
+func (d *Disassembler) instructions(out io.Writer, ins code.Instructions, positions code.Positions) {
+	line := 0
+
+	for i := 0; i < len(ins); {
+		if pos := positions.Lookup(i); pos.IsValid() && pos.Line != line {
+			line = pos.Line
+			fmt.Fprintf(out, "     ; %s\n", d.sourceLine(pos.Line))
+		}
+
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(out, "%04d ERROR: %s\n", i, err)
+			i++
+			continue
+		}
+
+		if i+def.Width() > len(ins) {
+			fmt.Fprintf(out, "%04d ERROR: %s is missing operands\n", i, def.Name)
+			return
+		}
+
+		operands, read := code.ReadOperands(def, ins[i+1:])
+
+		text := def.Name
+		for _, operand := range operands {
+			text += " " + strconv.Itoa(operand)
+		}
+
+		if comment := d.annotate(code.Opcode(ins[i]), operands); comment != "" {
+			fmt.Fprintf(out, "%04d %-24s ; %s\n", i, text, comment)
+		} else {
+			fmt.Fprintf(out, "%04d %s\n", i, text)
+		}
+
+		i += 1 + read
+	}
+}
+
+// annotate explains what the operands of an instruction refer to
+func (d *Disassembler) annotate(op code.Opcode, operands []int) string {
+	switch op {
+	case code.OpJump, code.OpJumpNotTruthy, code.OpSetupTry:
+		return fmt.Sprintf("-> %04d", operands[0])
+
+	case code.OpConstant, code.OpClosure:
+		if operands[0] >= len(d.bytecode.Constants) {
+			return "unknown constant"
+		}
+		return describe(d.bytecode.Constants[operands[0]])
+
+	case code.OpGetGlobal, code.OpSetGlobal:
+		return d.globals[operands[0]]
+
+	case code.OpGetBuiltin:
+		if operands[0] >= len(object.Builtins) {
+			return "unknown builtin"
+		}
+		return object.Builtins[operands[0]].Name
+	}
+
+	return ""
+}
+
+func (d *Disassembler) sourceLine(line int) string {
+	if line > len(d.lines) {
+		return fmt.Sprintf("line %d", line)
+	}
+	return fmt.Sprintf("%d: %s", line, strings.TrimSpace(d.lines[line-1]))
+}
+
+func describe(constant object.Object) string {
+	switch constant := constant.(type) {
+	case *object.String:
+		return strconv.Quote(constant.Value)
+	case *object.CompiledFunction:
+		return functionName(constant)
+	default:
+		return constant.Inspect()
+	}
+}
+
+func functionName(fn *object.CompiledFunction) string {
+	if fn.Name == "" {
+		return "fn <anonymous>"
+	}
+	return "fn " + fn.Name
+}
@@ -0,0 +1,93 @@
+package disasm
+
+import (
+	"bytes"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := `let f = fn(x) {
+  if (x) { len("ab") }
+};
+f(true);`
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := `== main ==
+     ; 1: let f = fn(x) {
+0000 OpClosure 1 0            ; fn f
+0004 OpSetGlobal 0            ; f
+     ; 4: f(true);
+0007 OpGetGlobal 0            ; f
+0010 OpTrue
+0011 OpCall 1
+0013 OpPop
+
+== constants ==
+   0 "ab"
+   1 fn f
+
+== fn f (constant 1, 1 params, 1 locals) ==
+     ; 2: if (x) { len("ab") }
+0000 OpGetLocal 0
+0002 OpJumpNotTruthy 15       ; -> 0015
+0005 OpGetBuiltin 0           ; len
+0007 OpConstant 0             ; "ab"
+0010 OpCall 1
+0012 OpJump 16                ; -> 0016
+0015 OpNull
+0016 OpReturnValue
+`
+
+	var out bytes.Buffer
+	New(comp.Bytecode(), symbolTable, input).Disassemble(&out)
+
+	if out.String() != expected {
+		t.Errorf("wrong disassembly.\nwant=%q\ngot=%q", expected, out.String())
+	}
+}
+
+func TestDisassembleInvalidInstructions(t *testing.T) {
+	instructions := code.Instructions{}
+	instructions = append(instructions, code.Make(code.OpConstant, 3)...)
+	instructions = append(instructions, 255)
+	instructions = append(instructions, code.Make(code.OpGetGlobal, 7)...)
+	instructions = append(instructions, byte(code.OpJump), 0)
+
+	bytecode := &compiler.Bytecode{Instructions: instructions}
+
+	expected := `== main ==
+0000 OpConstant 3             ; unknown constant
+0003 ERROR: opcode 255 undefined
+0004 OpGetGlobal 7
+0007 ERROR: OpJump is missing operands
+`
+
+	var out bytes.Buffer
+	New(bytecode, nil, "").Disassemble(&out)
+
+	if out.String() != expected {
+		t.Errorf("wrong disassembly.\nwant=%q\ngot=%q", expected, out.String())
+	}
+}
@@ -5,15 +5,29 @@ import (
 	"math"
 	"monkey/ast"
 	"monkey/object"
+	"monkey/token"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL     = object.NULL
+	TRUE     = object.TRUE
+	FALSE    = object.FALSE
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	result := eval(node, env)
+
+	// The innermost node that produced the error gives its position
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+
+	return result
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 
 	// statements
@@ -24,7 +38,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return evalBlockStatement(node, object.NewEnclosedEnvironment(env))
 
 	case *ast.ReturnStatement:
 		value := Eval(node.ReturnValue, env)
@@ -33,12 +47,34 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return &object.ReturnValue{Value: value}
 
+	case *ast.ThrowStatement:
+		value := Eval(node.Value, env)
+		if isError(value) {
+			return value
+		}
+		return object.NewThrownError(value)
+
+	case *ast.BreakStatement:
+		return BREAK
+
+	case *ast.ContinueStatement:
+		return CONTINUE
+
 	case *ast.LetStatement:
 		value := Eval(node.Value, env)
 		if isError(value) {
 			return value
 		}
-		env.Set(node.Name.Value, value)
+
+		var result object.Object
+		if node.IsConst() {
+			result = env.SetConst(node.Name.Value, value)
+		} else {
+			result = env.Set(node.Name.Value, value)
+		}
+		if isError(result) {
+			return result
+		}
 
 	// Expressions
 	case *ast.IntegerLiteral:
@@ -65,6 +101,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return evaluateAssignmentExpressions(node.Left, node.Right, env)
 		}
 
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
+
 		left = Eval(node.Left, env)
 		if isError(left) {
 			return left
@@ -84,7 +124,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Body: body, Parameters: params, Env: env}
+		return &object.Function{Body: body, Parameters: params, Env: env, Name: node.Name}
 
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
@@ -96,11 +136,17 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return applyFunction(function, args, node.Pos())
 
 	case *ast.LoopExpression:
 		return evalLoopExpression(node, env)
 
+	case *ast.ForExpression:
+		return evalForExpression(node, env)
+
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
@@ -229,6 +275,10 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 		return nativeBoolToBooleanObject(leftValue < rightValue)
 	case ">":
 		return nativeBoolToBooleanObject(leftValue > rightValue)
+	case "<=":
+		return nativeBoolToBooleanObject(leftValue <= rightValue)
+	case ">=":
+		return nativeBoolToBooleanObject(leftValue >= rightValue)
 	case "==":
 		return nativeBoolToBooleanObject(leftValue == rightValue)
 	case "!=":
@@ -271,6 +321,10 @@ func evalFloatInfixExpression(operator string, left, right object.Object) object
 		return nativeBoolToBooleanObject(leftValue < rightValue)
 	case ">":
 		return nativeBoolToBooleanObject(leftValue > rightValue)
+	case "<=":
+		return nativeBoolToBooleanObject(leftValue <= rightValue)
+	case ">=":
+		return nativeBoolToBooleanObject(leftValue >= rightValue)
 	case "==":
 		return nativeBoolToBooleanObject(leftValue == rightValue)
 	case "!=":
@@ -296,6 +350,29 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 	}
 }
 
+// The right operand is only evaluated when the left one doesn't decide the
+// result. Both operators evaluate to a boolean.
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
+	}
+
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
 	if isError(condition) {
@@ -331,7 +408,8 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 
 		if result != nil {
 			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ ||
+				rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ {
 				return result
 			}
 		}
@@ -375,14 +453,27 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// applyFunction calls fn from the call site at pos, which is recorded in the
+// stack of errors raised by the function's body.
+func applyFunction(fn object.Object, args []object.Object, pos token.Position) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
+
+		// The body shares the environment of the parameters
 		extendedEnv := extendedFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
+		evaluated := evalBlockStatement(fn.Body, extendedEnv)
+		if err, ok := evaluated.(*object.Error); ok {
+			err.Stack = append(err.Stack, object.StackFrame{Function: fn.Name, Pos: pos})
+		}
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		return fn.Fn(args...)
+		if result := fn.Fn(args...); result != nil {
+			return result
+		}
+		return NULL
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
@@ -406,22 +497,109 @@ func unwrapReturnValue(obj object.Object) object.Object {
 }
 
 func evalLoopExpression(le *ast.LoopExpression, env *object.Environment) object.Object {
-	var result object.Object
+	// The loop evaluates to the value of the last iteration's body, or null
+	// if the body never ran. An iteration cut short by break or continue
+	// has the value null.
+	var result object.Object = NULL
 	for {
 		condition := Eval(le.Condition, env)
-		if isTruthy(condition) {
-			result = Eval(le.Body, env)
-		} else {
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
 			break
 		}
+
+		var done bool
+		result, done = evalLoopBody(le.Body, env)
+		if done {
+			return result
+		}
 	}
 	return result
 }
 
+func evalForExpression(fe *ast.ForExpression, env *object.Environment) object.Object {
+	iterable := Eval(fe.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	it, ok := object.NewIterator(iterable)
+	if !ok {
+		return newError("cannot iterate over %s", iterable.Type())
+	}
+
+	// Same value as a loop expression
+	var result object.Object = NULL
+	for it.Next() {
+		// The loop variables are scoped to the iteration
+		iterationEnv := object.NewEnclosedEnvironment(env)
+		if fe.Key != nil {
+			iterationEnv.Set(fe.Key.Value, it.Key())
+			iterationEnv.Set(fe.Value.Value, it.Value())
+		} else {
+			iterationEnv.Set(fe.Value.Value, it.Element())
+		}
+
+		var done bool
+		result, done = evalLoopBody(fe.Body, iterationEnv)
+		if done {
+			return result
+		}
+	}
+	return result
+}
+
+// evalLoopBody runs a single iteration and reports whether the loop has to
+// stop, in which case the result is what the loop evaluates to.
+func evalLoopBody(body *ast.BlockStatement, env *object.Environment) (object.Object, bool) {
+	result := Eval(body, env)
+	switch result {
+	case nil, CONTINUE:
+		return NULL, false
+	case BREAK:
+		return NULL, true
+	}
+
+	if rt := result.Type(); rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+		return result, true
+	}
+	return result, false
+}
+
+func evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(te.Body, env)
+
+	if err, ok := result.(*object.Error); ok && te.Catch != nil {
+		catchEnv := object.NewEnclosedEnvironment(env)
+		if te.Param != nil {
+			catchEnv.Set(te.Param.Value, err.AsHash())
+		}
+		result = Eval(te.Catch, catchEnv)
+	}
+
+	// finally runs however the try ended. Its value is dropped unless it
+	// ends the try itself, by an error, return, break or continue.
+	if te.Finally != nil {
+		finally := Eval(te.Finally, env)
+		if finally != nil {
+			switch finally.Type() {
+			case object.ERROR_OBJ, object.RETURN_VALUE_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+				return finally
+			}
+		}
+	}
+
+	return result
+}
+
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
@@ -439,6 +617,17 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObj.Elements[idx]
 }
 
+// Strings are indexed by character, not by byte
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	chars := []rune(str.(*object.String).Value)
+	idx := index.(*object.Integer).Value
+	max := int64(len(chars) - 1)
+	if idx < 0 || idx > max {
+		return NULL
+	}
+	return &object.String{Value: string(chars[idx])}
+}
+
 func evaluateAssignmentExpressions(left ast.Expression, right ast.Expression, env *object.Environment) object.Object {
 	switch left := left.(type) {
 
@@ -450,8 +639,7 @@ func evaluateAssignmentExpressions(left ast.Expression, right ast.Expression, en
 		if isError(rightObj) {
 			return rightObj
 		}
-		env.Set(left.Value, rightObj)
-		return rightObj
+		return env.Assign(left.Value, rightObj)
 
 	case *ast.IndexExpression:
 		return evaluateIndexAssignmentExpression(left, right, env)
@@ -469,6 +657,11 @@ func evaluateIndexAssignmentExpression(left *ast.IndexExpression, right ast.Expr
 	if isError(index) {
 		return index
 	}
+	// Evaluated before the index is checked, like the VM does
+	rightObj := Eval(right, env)
+	if isError(rightObj) {
+		return rightObj
+	}
 
 	switch {
 
@@ -480,11 +673,6 @@ func evaluateIndexAssignmentExpression(left *ast.IndexExpression, right ast.Expr
 			return NULL
 		}
 
-		rightObj := Eval(right, env)
-		if isError(rightObj) {
-			return rightObj
-		}
-
 		if rightObj.Type() == object.ARRAY_OBJ {
 			rightArrObj := rightObj.(*object.Array)
 
@@ -506,16 +694,7 @@ func evaluateIndexAssignmentExpression(left *ast.IndexExpression, right ast.Expr
 			return newError("unusable as hash key: %s", index.Type())
 		}
 
-		rightObj := Eval(right, env)
-		if isError(rightObj) {
-			return rightObj
-		}
-
-		hashObject, ok := leftObj.(*object.Hash)
-		if !ok {
-			return NULL
-		}
-
+		hashObject := leftObj.(*object.Hash)
 		hashed := hashKey.HashKey()
 
 		if rightObj.Type() == object.HASH_OBJ {
@@ -533,7 +712,7 @@ func evaluateIndexAssignmentExpression(left *ast.IndexExpression, right ast.Expr
 		}
 
 		hashObject.Pairs[hashed] = object.HashPair{Key: index, Value: rightObj}
-		return hashObject
+		return rightObj
 
 	default:
 		return newError("index operator not supported: %s", leftObj.Type())
@@ -123,6 +123,22 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"2.5 >= 2", true},
+		{"2 <= 1.5", false},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 0", true},
+		{"if (false) { 1 } || 2 > 1", true},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 >= 3", false},
+		{"true || false && false", true},
 	}
 
 	for _, tt := range tests {
@@ -131,6 +147,38 @@ func TestEvalBooleanExpression(t *testing.T) {
 	}
 }
 
+func TestLogicalShortCircuit(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let a = 0; false && (a = 1); a", 0},
+		{"let a = 0; true && (a = 1); a", 1},
+		{"let a = 0; true || (a = 1); a", 0},
+		{"let a = 0; false || (a = 1); a", 1},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestComparisonOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let a = 0; (a = 1) < (a = 2); a", 2},
+		{"let a = 0; (a = 1) <= (a = 2); a", 2},
+		{"let a = 0; (a = 1) > (a = 2); a", 2},
+		{"let a = 0; (a = 1) >= (a = 2); a", 2},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
@@ -176,6 +224,11 @@ func TestIfElseExpressions(t *testing.T) {
 		{nil, "if (1 > 2) { 10 }"},
 		{20, "if (1 > 2) { 10 } else { 20 }"},
 		{10, "if (1 < 2) { 10 } else { 20 }"},
+		{20, "if (1 > 2) { 10 } else if (2 > 1) { 20 } else { 30 }"},
+		{30, "if (1 > 2) { 10 } else if (2 > 3) { 20 } else { 30 }"},
+		{nil, "if (1 > 2) { 10 } else if (2 > 3) { 20 }"},
+		{10, "if (1 < 2) { 10 } else if (2 > 1) { 20 } else { 30 }"},
+		{40, "let x = 4; if (x == 1) { 10 } else if (x == 2) { 20 } else if (x == 3) { 30 } else { 40 }"},
 	}
 
 	for _, tt := range tests {
@@ -301,10 +354,46 @@ func TestErrorHandling(t *testing.T) {
 			`"Hello" - "World"`,
 			"unknown operator: STRING - STRING",
 		},
+		{
+			`"a" > "b"`,
+			"unknown operator: STRING > STRING",
+		},
+		{
+			"true > false",
+			"unknown operator: BOOLEAN > BOOLEAN",
+		},
+		{
+			`"a" <= "b"`,
+			"unknown operator: STRING <= STRING",
+		},
 		{
 			`{"name": "Monkey"}[fn(x) { x }]`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			"const a = 1; a = 2;",
+			"cannot assign to constant a",
+		},
+		{
+			"const a = 1; if (true) { a = 2; }",
+			"cannot assign to constant a",
+		},
+		{
+			"if (true) { let a = 1; }; a;",
+			"identifier not found: a",
+		},
+		{
+			"for (x in [1]) { x }; x;",
+			"identifier not found: x",
+		},
+		{
+			"const a = 1; let a = 2;",
+			"cannot assign to constant a",
+		},
+		{
+			"const a = 1; let f = fn() { a = 2 }; f();",
+			"cannot assign to constant a",
+		},
 	}
 
 	for _, tt := range tests {
@@ -321,6 +410,63 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedInspect string
+	}{
+		{"5 + true;", "ERROR: 1:3: type mismatch: INTEGER + BOOLEAN"},
+		{"let a = 1;\n  foobar", "ERROR: 2:3: identifier not found: foobar"},
+		{"let f = fn() {\n  -true\n};\nf()", "ERROR: 2:3: unknown operator: -BOOLEAN"},
+		{`len(1)`, "ERROR: 1:4: argument to `len` not supported, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Inspect() != tt.expectedInspect {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expectedInspect, errObj.Inspect())
+		}
+	}
+}
+
+func TestErrorTraceback(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedTraceback string
+	}{
+		{"let f = fn() {\n  -true\n};\nf()", "ERROR: 2:3: unknown operator: -BOOLEAN\n  in f called at 4:2"},
+		{
+			"let add = fn(a, b) { a + b };\nlet g = fn() { add(1, true) };\ng();",
+			"ERROR: 1:24: type mismatch: INTEGER + BOOLEAN\n  in add called at 2:19\n  in g called at 3:2",
+		},
+		{
+			"let f = fn(n) { if (n == 0) { len(1) } else { f(n - 1) } };\nf(2)",
+			"ERROR: 1:34: argument to `len` not supported, got INTEGER\n  in f called at 1:48 (2 times)\n  in f called at 2:2",
+		},
+		{"let f = fn(a) { a };\nf()", "ERROR: 2:2: wrong number of arguments: want=1, got=0"},
+		{"fn() { -true }()", "ERROR: 1:8: unknown operator: -BOOLEAN\n  in <anonymous> called at 1:15"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Traceback() != tt.expectedTraceback {
+			t.Errorf("wrong traceback. expected=%q, got=%q", tt.expectedTraceback, errObj.Traceback())
+		}
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -396,8 +542,83 @@ func TestLoopExpression(t *testing.T) {
 		input    string
 		expected int64
 	}{
-		{"let i = 0; let num = 0; loop (i < 2) { let num = num + 1; let i = i + 1; }; num;", 2},
-		{"let iterator = fn(num) { let i = 0; loop (i < 10) { let i = i + 1; num = num + 1 }; num; }; iterator(0);", 10},
+		{"let i = 0; let num = 0; loop (i < 2) { num = num + 1; i = i + 1; }; num;", 2},
+		{"let iterator = fn(num) { let i = 0; loop (i < 10) { i = i + 1; num = num + 1 }; num; }; iterator(0);", 10},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestLoopExpressionValue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; loop (i < 3) { i = i + 1; i * 10 }", 30},
+		{"loop (false) { 10 }", nil},
+		{"let i = 0; loop (i < 3) { i = i + 1; let x = i; }", nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestBreakContinue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; loop (true) { i = i + 1; if (i == 5) { break; } }; i", 5},
+		{"let i = 0; let sum = 0; loop (i < 10) { i = i + 1; if (i % 2 == 0) { continue; } sum = sum + i; }; sum", 25},
+		{"let i = 0; loop (i < 3) { i = i + 1; if (i == 3) { break; } i * 10 }", nil},
+		{"let i = 0; loop (i < 3) { i = i + 1; if (i == 3) { continue; } i * 10 }", nil},
+		{"let i = 0; let n = 0; loop (i < 3) { i = i + 1; let j = 0; loop (true) { j = j + 1; if (j == 2) { break; } n = n + 1; } }; n", 3},
+		{"let f = fn() { let i = 0; loop (true) { i = i + 1; if (i == 4) { return i * 2; } } }; f()", 8},
+		{"let f = fn() { let i = 0; loop (i < 5) { i = i + 1; if (i < 5) { continue; } break; }; i }; f()", 5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestBlockScoping(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let x = 1; if (true) { let x = 2; }; x", 1},
+		{"let x = 1; if (true) { let x = 2; x }", 2},
+		{"let x = 1; if (true) { x = 2; }; x", 2},
+		{"let x = 1; if (false) { 0 } else { let x = 3; }; x", 1},
+		{"let a = 1; if (true) { let a = 2; if (true) { let a = 3; }; a }", 2},
+		{"let a = 1; if (true) { let b = a + 1; if (true) { a = b * 10 } }; a", 20},
+		{"let i = 0; let t = 0; loop (i < 3) { let x = i * 2; t = t + x; i = i + 1 }; t", 6},
+		{"let x = 10; for (x in [1, 2]) { x }; x", 10},
+		{"const c = 1; if (true) { let c = 2; c }", 2},
+		{"let f = fn(x) { if (true) { let x = x * 2; }; x }; f(5)", 5},
+		{"let x = 1; let f = fn() { if (true) { let x = 10; x } }; f() + x", 11},
+		{"let f = fn() { let fs = []; for (i in [1, 2, 3]) { fs = push(fs, fn() { i }) }; fs[0]() + fs[2]() }; f()", 4},
+		{"let f = fn() { let a = 1; if (true) { let b = 2; fn() { a + b } } }; f()()", 3},
+		{"let fs = []; for (i in [1, 2, 3]) { fs = push(fs, fn() { i }) }; fs[0]() + fs[2]()", 4},
+		{"let fs = []; let i = 0; loop (i < 3) { let k = i; fs = push(fs, fn() { k }); i = i + 1 }; fs[0]() + fs[2]()", 2},
+		{"let g = if (true) { let n = 0; fn() { n = n + 1; n } }; g(); g()", 2},
+		{"let f = if (true) { let a = 1; fn() { a } }; if (true) { let b = 2; f() + b }", 3},
 	}
 
 	for _, tt := range tests {
@@ -405,6 +626,173 @@ func TestLoopExpression(t *testing.T) {
 	}
 }
 
+func TestConstStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"const a = 5; a;", 5},
+		{"const a = 5; const b = a * 2; b;", 10},
+		{"const a = 5; let f = fn() { let a = 1; a = 2; a }; f();", 2},
+		{"const a = 5; let f = fn(a) { a = a + 1; a }; f(1);", 2},
+		{"const a = [1]; a[0] = 3; a[0];", 3},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestForExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let t = 0; for (x in [1, 2, 3]) { t = t + x }; t", 6},
+		{"for (x in [1, 2, 3]) { x * 10 }", 30},
+		{"for (x in []) { x }", nil},
+		{"let t = 0; for (i, x in [5, 6, 7]) { t = t + i * x }; t", 20},
+		{"let t = 0; for (k in {3: 1, 1: 2, 2: 3}) { t = t * 10 + k }; t", 123},
+		{"let t = 0; for (k, v in {3: 1, 1: 2, 2: 3}) { t = t * 10 + v }; t", 231},
+		{"let s = \"\"; for (c in \"abc\") { s = c + s }; s", "cba"},
+		{"let t = 0; for (i, c in \"abc\") { t = t + i }; t", 3},
+		{"let n = 0; for (c in \"añ世\") { n = n + 1 }; n * 10 + len(\"añ世\")", 33},
+		{"let s = \"añ世b\"; let same = 0; for (i, c in s) { if (s[i] == c) { same = same + 1 } }; same", 4},
+		{"let s = \"\"; for (c in \"añ世\") { s = c + s }; s", "世ña"},
+		{`"héllo"[1]`, "é"},
+		{`"abc"[3]`, nil},
+		{"let t = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } if (x == 4) { break; } t = t + x }; t", 4},
+		{"let t = 0; for (x in [1, 2]) { for (y in [10, 20]) { t = t + x * y } }; t", 90},
+		{"let a = [1, 2]; let n = 0; for (x in a) { a[0] = 5; n = n + 1 }; n", 2},
+		{"let f = fn(xs) { for (x in xs) { if (x > 1) { return x; } } }; f([1, 2, 3])", 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. got=%q, want=%q", str.Value, expected)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { throw "boom" } catch (e) { e["message"] }`, "boom"},
+		{`try { throw "boom" } catch (e) { e["type"] }`, "Error"},
+		{`try { throw {"message": "bad", "type": "ValueError"} } catch (e) { e["type"] + ": " + e["message"] }`, "ValueError: bad"},
+		{`try { throw 42 } catch (e) { e["message"] }`, "42"},
+		{`try { 1 + true } catch (e) { e["type"] }`, "RuntimeError"},
+		{`try { len(1) } catch (e) { e["message"] }`, "argument to `len` not supported, got INTEGER"},
+		{`try { throw "x" } catch { 2 }`, 2},
+		{`let x = 0; try { x = 1 } finally { x = x + 10 }; x`, 11},
+		{`let x = 0; try { throw "x" } catch (e) { x = 1 } finally { x = x + 10 }; x`, 11},
+		{`try { 1 } finally { 2 }`, 1},
+		{`1 + try { throw "x" } catch (e) { 2 }`, 3},
+		{`let f = fn(n) { if (n == 0) { throw "bottom" } f(n - 1) }; try { f(5) } catch (e) { e["message"] }`, "bottom"},
+		{`let f = fn() { throw "inner" }; let g = fn() { try { f() } catch (e) { "caught " + e["message"] } }; g()`, "caught inner"},
+		{`try { try { throw "a" } catch (e) { throw e["message"] + "b" } } catch (e) { e["message"] }`, "ab"},
+		{`let n = 0; try { try { throw "a" } finally { n = n + 1 } } catch (e) { n = n + 10 }; n`, 11},
+		{`let n = 0; try { try { throw "a" } catch (e) { throw "b" } finally { n = n + 1 } } catch (e) { n = n + 10 }; n`, 11},
+		{`let n = 0; let f = fn() { try { return 1 } finally { n = 5 } }; f() + n`, 6},
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, 2},
+		{`let n = 0; let i = 0; loop (i < 4) { i = i + 1; try { if (i == 2) { continue } if (i == 3) { break } } finally { n = n + 1 } }; n`, 3},
+		{`let i = 0; loop (i < 5000) { i = i + 1; try { throw "x" } finally { continue } }; i`, 5000},
+		{`let i = 0; loop (i < 5000) { i = i + 1; try { i } finally { continue } }; i`, 5000},
+		{`let i = 0; loop (true) { i = i + 1; try { throw "x" } catch (e) { i } finally { if (i == 5000) { break } } }; i`, 5000},
+		{`let f = fn() { let i = 0; loop (i < 5000) { i = i + 1; try { return 1 } finally { continue } }; i }; f()`, 5000},
+		{`let t = 0; for (x in [1, 2, 3]) { try { throw "x" } finally { t = t + x; if (x == 2) { break } else { continue } } }; t`, 3},
+		{`let t = 0; for (x in [1, 2, 3]) { try { if (x == 2) { throw "skip" } t = t + x } catch (e) { t = t + 100 } }; t`, 104},
+		{`let f = fn() { let i = 0; loop (true) { i = i + 1; try { if (i == 3) { return i } } catch (e) { 0 } } }; try { f() } catch (e) { -1 }`, 3},
+		{`let f = fn() { try { return 1 } catch (e) { 0 } }; f(); try { throw "after" } catch (e) { e["message"] }`, "after"},
+		{`let e = 1; try { throw "x" } catch (e) { e }; e`, 1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String for %q. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value for %q. got=%q, want=%q", tt.input, str.Value, expected)
+			}
+		}
+	}
+}
+
+func TestUncaughtErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{`throw "boom"`, "boom"},
+		{`throw {"message": "bad"}`, "bad"},
+		{`let n = 0; try { throw "kept" } finally { n = 1 }`, "kept"},
+		{`try { throw "a" } catch (e) { throw e }`, "a"},
+		{`try { 1 + true } catch (e) { 1 }; -true`, "unknown operator: -BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = 1; a = 2", 2},
+		{"let arr = [1, 2, 3]; arr[1] = 10", 10},
+		{"let arr = [1, 2, 3]; arr[5] = 10", nil},
+		{`let h = {}; h["a"] = 2`, 2},
+		{"let n = 0; let arr = [1]; arr[5] = fn() { n = n + 1 }(); n", 1},
+		{"let f = fn() { f = 2; }; f(); f", 2},
+		{"let g = fn() { let f = fn() { f = 3 }; f(); f }; g()", 3},
+		{"if (true) { let f = fn() { f = 4 }; f(); f }", 4},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -487,6 +875,7 @@ func TestBuiltInFunctions(t *testing.T) {
 		{0, `len("")`},
 		{4, `len("four")`},
 		{11, `len("hello world")`},
+		{9, `len("héllo, 世界")`},
 		{"argument to `len` not supported, got INTEGER", `len(1)`},
 		{"wrong number of arguments. got=2, want=1", `len("one", "two")`},
 		{3, `len([1, 2, 3])`},
@@ -499,6 +888,8 @@ func TestBuiltInFunctions(t *testing.T) {
 		{nil, `last([])`},
 		{[]int{2, 3}, `rest([1, 2, 3])`},
 		{nil, `rest([])`},
+		{"argument to `rest` must be an ARRAY, got INTEGER", `rest(1)`},
+		{nil, `puts("hello")`},
 		{[]int{1}, `push([], 1)`},
 		{"argument to `push` must be an ARRAY, got INTEGER", `push(1, 1)`},
 	}
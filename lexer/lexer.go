@@ -14,6 +14,10 @@ type Lexer struct {
 	position     int  // current position in input (points to current char)
 	readPosition int  // current reading position in input (after current char)
 	ch           byte // current char under examination
+
+	file   string
+	line   int // line of the current char
+	column int // column of the current char
 }
 
 func (l *Lexer) peekChar() byte {
@@ -24,6 +28,12 @@ func (l *Lexer) peekChar() byte {
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -47,6 +57,31 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
+func (l *Lexer) skipLineComment() {
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+}
+
+// skipBlockComment returns false if the input ends before the closing */
+func (l *Lexer) skipBlockComment() bool {
+	// skip the opening /*
+	l.readChar()
+	l.readChar()
+
+	for {
+		switch {
+		case l.ch == 0:
+			return false
+		case l.ch == '*' && l.peekChar() == '/':
+			l.readChar()
+			l.readChar()
+			return true
+		}
+		l.readChar()
+	}
+}
+
 func (l *Lexer) readString() string {
 	var out []byte
 	state := UNESCAPED
@@ -119,11 +154,25 @@ func newToken(tokenType token.TokenType, ch byte) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
+// readTwoCharToken consumes the current char, the token ends on the next one
+func (l *Lexer) readTwoCharToken(tokenType token.TokenType) token.Token {
+	ch := l.ch
+	l.readChar()
+	literal := string(ch) + string(l.ch)
+	return token.Token{Type: tokenType, Literal: literal}
+}
+
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{File: l.file, Line: l.line, Column: l.column}
+}
+
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
 
+	pos := l.currentPosition()
+
 	switch l.ch {
 	case '"':
 		s := l.readString()
@@ -154,13 +203,43 @@ func (l *Lexer) NextToken() token.Token {
 	case '*':
 		tok = newToken(token.ASTERISK, l.ch)
 	case '/':
+		if l.peekChar() == '/' {
+			l.skipLineComment()
+			return l.NextToken()
+		} else if l.peekChar() == '*' {
+			start := l.position
+			if !l.skipBlockComment() {
+				return token.Token{Type: token.ILLEGAL, Literal: l.input[start:], Pos: pos}
+			}
+			return l.NextToken()
+		}
 		tok = newToken(token.SLASH, l.ch)
 	case '%':
 		tok = newToken(token.MODULUS, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.LT_EQ)
+		} else {
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.GT_EQ)
+		} else {
+			tok = newToken(token.GT, l.ch)
+		}
+	case '&':
+		if l.peekChar() == '&' {
+			tok = l.readTwoCharToken(token.AND)
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			tok = l.readTwoCharToken(token.OR)
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case '(':
@@ -186,20 +265,30 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos = pos
 			return tok
 		} else if isDigit(l.ch) {
 			tok.Literal, tok.Type = l.readNumber()
+			tok.Pos = pos
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
 		}
 	}
 	l.readChar()
+	tok.Pos = pos
 	return tok
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
+
+// NewWithFile records the file name in the position of every token
+func NewWithFile(input string, file string) *Lexer {
+	l := New(input)
+	l.file = file
+	return l
+}
@@ -15,7 +15,7 @@ func TestNextToken(t *testing.T) {
 	 };
 
 	 let result = add(five, ten);
-	 !-/*5;
+	 !-/ *5;
 	 5 < 10 > 5;
 
 	 if (5 < 10) {
@@ -162,3 +162,125 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `let five = 5;
+  five + "a
+b" ;
+x`
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  token.Position
+	}{
+		{token.LET, token.Position{File: "test.wf", Line: 1, Column: 1}},
+		{token.IDENT, token.Position{File: "test.wf", Line: 1, Column: 5}},
+		{token.ASSIGN, token.Position{File: "test.wf", Line: 1, Column: 10}},
+		{token.INT, token.Position{File: "test.wf", Line: 1, Column: 12}},
+		{token.SEMICOLON, token.Position{File: "test.wf", Line: 1, Column: 13}},
+		{token.IDENT, token.Position{File: "test.wf", Line: 2, Column: 3}},
+		{token.PLUS, token.Position{File: "test.wf", Line: 2, Column: 8}},
+		{token.STRING, token.Position{File: "test.wf", Line: 2, Column: 10}},
+		{token.SEMICOLON, token.Position{File: "test.wf", Line: 3, Column: 4}},
+		{token.IDENT, token.Position{File: "test.wf", Line: 4, Column: 1}},
+		{token.EOF, token.Position{File: "test.wf", Line: 4, Column: 2}},
+	}
+
+	l := NewWithFile(input, "test.wf")
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("tests[%d] - position wrong. expected=%s, got=%s", i, tt.expectedPos, tok.Pos)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// a line comment
+let a = 5; // trailing comment
+/* a block
+   comment */ a / 2;
+a /* inline */ * 2;
+/* unterminated`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "a"},
+		{token.ASSIGN, "="},
+		{token.INT, "5"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.ASTERISK, "*"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.ILLEGAL, "/* unterminated"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComparisonAndLogicalOperators(t *testing.T) {
+	input := `a <= b >= c && d || e < f > g & |`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.AND, "&&"},
+		{token.IDENT, "d"},
+		{token.OR, "||"},
+		{token.IDENT, "e"},
+		{token.LT, "<"},
+		{token.IDENT, "f"},
+		{token.GT, ">"},
+		{token.IDENT, "g"},
+		{token.ILLEGAL, "&"},
+		{token.ILLEGAL, "|"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
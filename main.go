@@ -1,19 +1,295 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/disasm"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
 	"monkey/repl"
+	"monkey/vm"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
 )
 
+const (
+	EXIT_OK    = 0
+	EXIT_ERROR = 1
+	EXIT_USAGE = 2
+)
+
+const usage = `Usage:
+  waffle [flags]                      start the REPL
+  waffle [flags] script.wf [args...]  run a script
+  waffle [flags] -e expr [args...]    run an expression
+  waffle -c script.wf                 compile a script to script.wfc
+  waffle script.wfc [args...]         run a compiled script
+  waffle disasm script.wf             print the bytecode of a script
+
+Flags:
+`
+
 func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(arguments []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	object.Output = stdout
+
+	if len(arguments) > 0 && arguments[0] == "disasm" {
+		return disassemble(arguments[1:], stdout, stderr)
+	}
+
+	flags := flag.NewFlagSet("waffle", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	engine := flags.String("engine", "vm", "execution engine, `eval` or vm")
+	expression := flags.String("e", "", "run the given `expr` instead of a script")
+	compileOnly := flags.Bool("c", false, "compile the script to a .wfc file instead of running it")
+
+	if err := flags.Parse(arguments); err != nil {
+		if err == flag.ErrHelp {
+			return EXIT_OK
+		}
+		return EXIT_USAGE
+	}
+
+	if *engine != "eval" && *engine != "vm" {
+		fmt.Fprintf(stderr, "unknown engine %q, want eval or vm\n", *engine)
+		return EXIT_USAGE
+	}
+
+	// -e is checked with Visit so that an empty expression still counts
+	expressionSet := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "e" {
+			expressionSet = true
+		}
+	})
+
+	if *compileOnly && (expressionSet || flags.NArg() != 1) {
+		fmt.Fprintln(stderr, "-c needs exactly one script to compile")
+		return EXIT_USAGE
+	}
+
+	if expressionSet {
+		return runSource(*expression, "-e", *engine, flags.Args(), stderr)
+	}
+
+	if flags.NArg() == 0 {
+		startRepl(stdin, stdout)
+		return EXIT_OK
+	}
+
+	file := flags.Arg(0)
+	src, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(stderr, "could not read script: %s\n", err)
+		return EXIT_ERROR
+	}
+
+	if *compileOnly {
+		return compileFile(string(src), file, stderr)
+	}
+
+	if filepath.Ext(file) == ".wfc" {
+		if *engine != "vm" {
+			fmt.Fprintln(stderr, "compiled scripts can only run on the vm engine")
+			return EXIT_USAGE
+		}
+
+		bytecode, err := compiler.Decode(src)
+		if err != nil {
+			fmt.Fprintf(stderr, "could not load %s: %s\n", file, err)
+			return EXIT_ERROR
+		}
+		return runBytecode(bytecode, flags.Args()[1:], stderr)
+	}
+
+	return runSource(string(src), file, *engine, flags.Args()[1:], stderr)
+}
+
+func startRepl(in io.Reader, out io.Writer) {
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
 
-	fmt.Printf("Hello %s! This is the Waffle programming language!\n", user.Username)
-	fmt.Println("Feel free to type in commands")
-	repl.Start(os.Stdin, os.Stdout)
+	fmt.Fprintf(out, "Hello %s! This is the Waffle programming language!\n", user.Username)
+	fmt.Fprintln(out, "Feel free to type in commands")
+	repl.Start(in, out)
+}
+
+// runSource executes a whole program non-interactively. The script arguments
+// are available to the program as the `args` array of strings.
+func runSource(src string, file string, engine string, scriptArgs []string, stderr io.Writer) int {
+	program, ok := parseSource(src, file, stderr)
+	if !ok {
+		return EXIT_ERROR
+	}
+
+	if engine == "eval" {
+		env := object.NewEnvironment()
+		env.Set("args", argsArray(scriptArgs))
+
+		evaluated := evaluator.Eval(program, env)
+		if err, ok := evaluated.(*object.Error); ok {
+			fmt.Fprintln(stderr, err.Traceback())
+			return EXIT_ERROR
+		}
+		return EXIT_OK
+	}
+
+	bytecode, _, err := compileProgram(program)
+	if err != nil {
+		fmt.Fprintf(stderr, "compilation failed: %s\n", err)
+		return EXIT_ERROR
+	}
+
+	return runBytecode(bytecode, scriptArgs, stderr)
+}
+
+// compileFile writes the bytecode of a script next to it, with the .wfc
+// extension, to be run later without parsing and compiling it again.
+func compileFile(src string, file string, stderr io.Writer) int {
+	program, ok := parseSource(src, file, stderr)
+	if !ok {
+		return EXIT_ERROR
+	}
+
+	bytecode, _, err := compileProgram(program)
+	if err != nil {
+		fmt.Fprintf(stderr, "compilation failed: %s\n", err)
+		return EXIT_ERROR
+	}
+
+	data, err := compiler.Encode(bytecode)
+	if err != nil {
+		fmt.Fprintf(stderr, "compilation failed: %s\n", err)
+		return EXIT_ERROR
+	}
+
+	out := strings.TrimSuffix(file, filepath.Ext(file)) + ".wfc"
+	err = os.WriteFile(out, data, 0644)
+	if err != nil {
+		fmt.Fprintf(stderr, "could not write bytecode: %s\n", err)
+		return EXIT_ERROR
+	}
+
+	return EXIT_OK
+}
+
+func parseSource(src string, file string, stderr io.Writer) (*ast.Program, bool) {
+	l := lexer.NewWithFile(src, file)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		fmt.Fprintln(stderr, "parser errors:")
+		for _, msg := range p.Errors() {
+			fmt.Fprintf(stderr, "\t%s\n", msg)
+		}
+		return nil, false
+	}
+
+	return program, true
+}
+
+// newSymbolTable returns the symbol table scripts are compiled with. `args`
+// is always the first global, compiled scripts rely on it when they run.
+func newSymbolTable() (*compiler.SymbolTable, compiler.Symbol) {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	return symbolTable, symbolTable.Define("args")
+}
+
+func compileProgram(program *ast.Program) (*compiler.Bytecode, *compiler.SymbolTable, error) {
+	symbolTable, _ := newSymbolTable()
+
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	err := comp.Compile(program)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return comp.Bytecode(), symbolTable, nil
+}
+
+// disassemble prints the bytecode of a script, or of a compiled script
+// without global names and source lines.
+func disassemble(arguments []string, stdout, stderr io.Writer) int {
+	if len(arguments) != 1 {
+		fmt.Fprintln(stderr, "usage: waffle disasm script.wf")
+		return EXIT_USAGE
+	}
+
+	file := arguments[0]
+	src, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(stderr, "could not read script: %s\n", err)
+		return EXIT_ERROR
+	}
+
+	if filepath.Ext(file) == ".wfc" {
+		bytecode, err := compiler.Decode(src)
+		if err != nil {
+			fmt.Fprintf(stderr, "could not load %s: %s\n", file, err)
+			return EXIT_ERROR
+		}
+
+		disasm.New(bytecode, nil, "").Disassemble(stdout)
+		return EXIT_OK
+	}
+
+	program, ok := parseSource(string(src), file, stderr)
+	if !ok {
+		return EXIT_ERROR
+	}
+
+	bytecode, symbolTable, err := compileProgram(program)
+	if err != nil {
+		fmt.Fprintf(stderr, "compilation failed: %s\n", err)
+		return EXIT_ERROR
+	}
+
+	disasm.New(bytecode, symbolTable, string(src)).Disassemble(stdout)
+	return EXIT_OK
+}
+
+func runBytecode(bytecode *compiler.Bytecode, scriptArgs []string, stderr io.Writer) int {
+	_, argsSymbol := newSymbolTable()
+
+	globals := make([]object.Object, vm.GLOBALSSIZE)
+	globals[argsSymbol.Index] = argsArray(scriptArgs)
+
+	machine := vm.NewWithGlobalStore(bytecode, globals)
+	err := machine.Run()
+	if err != nil {
+		fmt.Fprintln(stderr, err.(*object.Error).Traceback())
+		return EXIT_ERROR
+	}
+
+	return EXIT_OK
+}
+
+func argsArray(scriptArgs []string) *object.Array {
+	elements := make([]object.Object, len(scriptArgs))
+	for i, arg := range scriptArgs {
+		elements[i] = &object.String{Value: arg}
+	}
+	return &object.Array{Elements: elements}
 }
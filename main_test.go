@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.wf")
+	err := os.WriteFile(script, []byte("puts(len(args), args[0])"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		engine    string // empty to run with both engines
+		arguments []string
+		stdout    string
+		stderr    string // expected prefix
+		exitCode  int
+	}{
+		{"", []string{"-e", "puts(1 + 2)"}, "3\n", "", EXIT_OK},
+		{"", []string{"-e", "puts(args)", "a", "b"}, "[a, b]\n", "", EXIT_OK},
+		{"", []string{"-e", ""}, "", "", EXIT_OK},
+		{"", []string{script, "x", "y"}, "2\nx\n", "", EXIT_OK},
+		{"", []string{"-e", "let"}, "", "parser errors:\n\t-e:1:4: expected next token to be IDENT", EXIT_ERROR},
+		{"", []string{"-e", "puts(1); throw \"boom\"; puts(2)"}, "1\n", "ERROR: -e:1:10: boom", EXIT_ERROR},
+		{"", []string{filepath.Join(dir, "missing.wf")}, "", "could not read script", EXIT_ERROR},
+		{"", []string{"-c"}, "", "-c needs exactly one script to compile", EXIT_USAGE},
+		{"", []string{"-unknown"}, "", "flag provided but not defined: -unknown", EXIT_USAGE},
+		// The VM finds undefined variables before running anything
+		{"vm", []string{"-e", "puts(1); x"}, "", "compilation failed: -e:1:10: undefined variable x", EXIT_ERROR},
+		{"eval", []string{"-e", "puts(1); x"}, "1\n", "ERROR: -e:1:10: identifier not found: x", EXIT_ERROR},
+		{"js", []string{"-e", "1"}, "", "unknown engine \"js\"", EXIT_USAGE},
+		{"", []string{"--help"}, "", "Usage:", EXIT_OK},
+	}
+
+	for _, tt := range tests {
+		engines := []string{tt.engine}
+		if tt.engine == "" {
+			engines = []string{"vm", "eval"}
+		}
+
+		for _, engine := range engines {
+			arguments := append([]string{"--engine=" + engine}, tt.arguments...)
+			stdout, stderr, exitCode := runMain(arguments)
+
+			if exitCode != tt.exitCode {
+				t.Errorf("%v: wrong exit code. want=%d, got=%d (stderr %q)", arguments, tt.exitCode, exitCode, stderr)
+			}
+			if stdout != tt.stdout {
+				t.Errorf("%v: wrong stdout. want=%q, got=%q", arguments, tt.stdout, stdout)
+			}
+			if !strings.HasPrefix(stderr, tt.stderr) || (tt.stderr == "" && stderr != "") {
+				t.Errorf("%v: wrong stderr. want prefix %q, got=%q", arguments, tt.stderr, stderr)
+			}
+		}
+	}
+}
+
+func TestCompiledScripts(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.wf")
+	err := os.WriteFile(script, []byte("let t = 0; for (a in args) { t = t + len(a) }; puts(t)"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, exitCode := runMain([]string{"-c", script})
+	if exitCode != EXIT_OK || stdout != "" || stderr != "" {
+		t.Fatalf("-c failed with exit code %d, stdout %q and stderr %q", exitCode, stdout, stderr)
+	}
+
+	compiled := filepath.Join(dir, "script.wfc")
+	data, err := os.ReadFile(compiled)
+	if err != nil {
+		t.Fatalf("-c did not write the compiled script: %s", err)
+	}
+
+	// The source isn't needed anymore
+	os.Remove(script)
+
+	stdout, stderr, exitCode = runMain([]string{compiled, "ab", "cde"})
+	if exitCode != EXIT_OK || stdout != "5\n" || stderr != "" {
+		t.Errorf("running the compiled script gave exit code %d, stdout %q and stderr %q", exitCode, stdout, stderr)
+	}
+
+	_, stderr, exitCode = runMain([]string{"--engine=eval", compiled})
+	if exitCode != EXIT_USAGE || !strings.HasPrefix(stderr, "compiled scripts can only run on the vm engine") {
+		t.Errorf("eval engine gave exit code %d and stderr %q", exitCode, stderr)
+	}
+
+	data[len(data)-1] ^= 1
+	err = os.WriteFile(compiled, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, stderr, exitCode = runMain([]string{compiled})
+	if exitCode != EXIT_ERROR || !strings.Contains(stderr, "bytecode checksum mismatch") {
+		t.Errorf("damaged script gave exit code %d and stderr %q", exitCode, stderr)
+	}
+}
+
+func runMain(arguments []string) (string, string, int) {
+	var stdout, stderr bytes.Buffer
+	exitCode := run(arguments, strings.NewReader(""), &stdout, &stderr)
+	return stdout.String(), stderr.String(), exitCode
+}
@@ -0,0 +1,387 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// FromGo converts a Go value to a Waffle value. Integers of any size become
+// INTEGER, floats FLOAT, slices and arrays ARRAY, and maps with string,
+// integer or boolean keys HASH. nil and nil pointers become null, and
+// Objects are returned unchanged.
+func FromGo(value interface{}) (Object, error) {
+	if value == nil {
+		return NULL, nil
+	}
+	return fromGo(reflect.ValueOf(value))
+}
+
+func fromGo(v reflect.Value) (Object, error) {
+	if obj, ok := v.Interface().(Object); ok {
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return NULL, nil
+		}
+		return obj, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return TRUE, nil
+		}
+		return FALSE, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows INTEGER", v.Uint())
+		}
+		return &Integer{Value: int64(v.Uint())}, nil
+
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return NULL, nil
+		}
+
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			element, err := fromGo(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return &Array{Elements: elements}, nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			return NULL, nil
+		}
+
+		hash := &Hash{Pairs: map[HashKey]HashPair{}}
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := fromGo(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			hashable, ok := key.(Hashable)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+
+			value, err := fromGo(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			hash.Pairs[hashable.HashKey()] = HashPair{Key: key, Value: value}
+		}
+		return hash, nil
+
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return fromGo(v.Elem())
+	}
+
+	return nil, fmt.Errorf("cannot convert Go value of type %s", v.Type())
+}
+
+// ToGo stores a Waffle value in the Go value target points to, converting
+// it to the target's type. An interface{} target gets the natural Go type:
+// int64, float64, string, bool, nil, []interface{} for arrays, and
+// map[string]interface{} for hashes, or map[interface{}]interface{} if some
+// of their keys aren't strings.
+func ToGo(obj Object, target interface{}) error {
+	ptr := reflect.ValueOf(target)
+	if ptr.Kind() != reflect.Pointer || ptr.IsNil() {
+		return fmt.Errorf("ToGo needs a non-nil pointer, got %T", target)
+	}
+
+	v, err := toGo(obj, ptr.Type().Elem())
+	if err != nil {
+		return err
+	}
+
+	ptr.Elem().Set(v)
+	return nil
+}
+
+func toGo(obj Object, typ reflect.Type) (reflect.Value, error) {
+	if obj == nil {
+		obj = NULL
+	}
+
+	if typ.Kind() == reflect.Interface && typ.NumMethod() == 0 {
+		return naturalGo(obj)
+	}
+
+	// Parameters can take Waffle values as they are, e.g. an Object or *Hash
+	if reflect.TypeOf(obj).AssignableTo(typ) {
+		v := reflect.New(typ).Elem()
+		v.Set(reflect.ValueOf(obj))
+		return v, nil
+	}
+
+	if obj.Type() == NULL_OBJ {
+		switch typ.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+			return reflect.Zero(typ), nil
+		}
+	}
+
+	fail := func() (reflect.Value, error) {
+		return reflect.Value{}, fmt.Errorf("cannot use %s as %s", obj.Type(), typ)
+	}
+
+	v := reflect.New(typ).Elem()
+
+	switch typ.Kind() {
+	case reflect.Bool:
+		boolean, ok := obj.(*Boolean)
+		if !ok {
+			return fail()
+		}
+		v.SetBool(boolean.Value)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		integer, ok := obj.(*Integer)
+		if !ok {
+			return fail()
+		}
+		if v.OverflowInt(integer.Value) {
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", integer.Value, typ)
+		}
+		v.SetInt(integer.Value)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		integer, ok := obj.(*Integer)
+		if !ok {
+			return fail()
+		}
+		if integer.Value < 0 || v.OverflowUint(uint64(integer.Value)) {
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", integer.Value, typ)
+		}
+		v.SetUint(uint64(integer.Value))
+
+	case reflect.Float32, reflect.Float64:
+		switch number := obj.(type) {
+		case *Float:
+			v.SetFloat(number.Value)
+		case *Integer:
+			v.SetFloat(float64(number.Value))
+		default:
+			return fail()
+		}
+
+	case reflect.String:
+		str, ok := obj.(*String)
+		if !ok {
+			return fail()
+		}
+		v.SetString(str.Value)
+
+	case reflect.Slice:
+		array, ok := obj.(*Array)
+		if !ok {
+			return fail()
+		}
+
+		v = reflect.MakeSlice(typ, len(array.Elements), len(array.Elements))
+		for i, element := range array.Elements {
+			converted, err := toGo(element, typ.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v.Index(i).Set(converted)
+		}
+
+	case reflect.Map:
+		hash, ok := obj.(*Hash)
+		if !ok {
+			return fail()
+		}
+
+		v = reflect.MakeMapWithSize(typ, len(hash.Pairs))
+		for _, pair := range hash.SortedPairs() {
+			key, err := toGo(pair.Key, typ.Key())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			value, err := toGo(pair.Value, typ.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v.SetMapIndex(key, value)
+		}
+
+	case reflect.Pointer:
+		elem, err := toGo(obj, typ.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v = reflect.New(typ.Elem())
+		v.Elem().Set(elem)
+
+	default:
+		return fail()
+	}
+
+	return v, nil
+}
+
+func naturalGo(obj Object) (reflect.Value, error) {
+	var result interface{}
+
+	switch obj := obj.(type) {
+	case *Integer:
+		result = obj.Value
+	case *Float:
+		result = obj.Value
+	case *String:
+		result = obj.Value
+	case *Boolean:
+		result = obj.Value
+	case *Null:
+		result = nil
+
+	case *Array:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, element := range obj.Elements {
+			v, err := naturalGo(element)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			elements[i] = v.Interface()
+		}
+		result = elements
+
+	case *Hash:
+		stringKeys := true
+		for _, pair := range obj.Pairs {
+			if pair.Key.Type() != STRING_OBJ {
+				stringKeys = false
+			}
+		}
+
+		anyKeys := map[interface{}]interface{}{}
+		for _, pair := range obj.Pairs {
+			key, err := naturalGo(pair.Key)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			value, err := naturalGo(pair.Value)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			anyKeys[key.Interface()] = value.Interface()
+		}
+
+		if !stringKeys {
+			result = anyKeys
+			break
+		}
+
+		stringMap := make(map[string]interface{}, len(anyKeys))
+		for key, value := range anyKeys {
+			stringMap[key.(string)] = value
+		}
+		result = stringMap
+
+	default:
+		return reflect.Value{}, fmt.Errorf("cannot convert %s to a Go value", obj.Type())
+	}
+
+	return reflect.ValueOf(&result).Elem(), nil
+}
+
+// NewGoBuiltin wraps a Go function as a builtin. Its arguments are converted
+// from Waffle values as ToGo does and its result back with FromGo. The
+// function may return nothing, a value, an error, or a value and an error;
+// a non-nil error is raised as a Waffle error, and so is a panic.
+func NewGoBuiltin(name string, fn interface{}) (*Builtin, error) {
+	fnValue := reflect.ValueOf(fn)
+	fnType := fnValue.Type()
+	if fnType.Kind() != reflect.Func || fnValue.IsNil() {
+		return nil, fmt.Errorf("builtin %s must be a function, got %T", name, fn)
+	}
+
+	numOut := fnType.NumOut()
+	returnsError := numOut > 0 && fnType.Out(numOut-1) == errorType
+	if numOut > 2 || (numOut == 2 && !returnsError) {
+		return nil, fmt.Errorf("builtin %s must return at most a value and an error, got %s", name, fnType)
+	}
+
+	numParams := fnType.NumIn()
+	variadic := fnType.IsVariadic()
+
+	return &Builtin{Fn: func(args ...Object) Object {
+		if variadic && len(args) < numParams-1 {
+			return newError("wrong number of arguments. got=%d, want at least %d", len(args), numParams-1)
+		}
+		if !variadic && len(args) != numParams {
+			return newError("wrong number of arguments. got=%d, want=%d", len(args), numParams)
+		}
+
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var typ reflect.Type
+			if variadic && i >= numParams-1 {
+				typ = fnType.In(numParams - 1).Elem()
+			} else {
+				typ = fnType.In(i)
+			}
+
+			v, err := toGo(arg, typ)
+			if err != nil {
+				return newError("argument %d to `%s`: %s", i+1, name, err)
+			}
+			in[i] = v
+		}
+
+		out, panicked := callGo(name, fnValue, in)
+		if panicked != nil {
+			return panicked
+		}
+
+		if returnsError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return newError("%s", err)
+			}
+			out = out[:len(out)-1]
+		}
+
+		if len(out) == 0 {
+			return NULL
+		}
+
+		result, err := fromGo(out[0])
+		if err != nil {
+			return newError("result of `%s`: %s", name, err)
+		}
+		return result
+	}}, nil
+}
+
+// callGo calls fn with in, turning a panic into an error
+func callGo(name string, fn reflect.Value, in []reflect.Value) (out []reflect.Value, err *Error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = newError("`%s` panicked: %v", name, r)
+		}
+	}()
+
+	return fn.Call(in), nil
+}
@@ -0,0 +1,140 @@
+package object
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFromGo(t *testing.T) {
+	var nilPointer *int
+	seven := 7
+
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{int8(-3), "-3"},
+		{uint16(40), "40"},
+		{2.5, "2.5"},
+		{"hi", "hi"},
+		{[]int{1, 2}, "[1, 2]"},
+		{[2]string{"a", "b"}, "[a, b]"},
+		{[]interface{}{1, "a", nil}, "[1, a, null]"},
+		{map[string]int{"a": 1}, "{a: 1}"},
+		{map[int][]bool{1: {true}}, "{1: [true]}"},
+		{nilPointer, "null"},
+		{&seven, "7"},
+		{&Integer{Value: 3}, "3"},
+		{[]Object{TRUE}, "[true]"},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.input)
+		if err != nil {
+			t.Errorf("unexpected error for %#v: %s", tt.input, err)
+			continue
+		}
+
+		if obj.Inspect() != tt.expected {
+			t.Errorf("wrong conversion of %#v. want=%s, got=%s", tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	if obj, _ := FromGo(false); obj != FALSE {
+		t.Errorf("booleans are not converted to the FALSE singleton")
+	}
+
+	errorTests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{uint64(1 << 63), "9223372036854775808 overflows INTEGER"},
+		{struct{}{}, "cannot convert Go value of type struct {}"},
+		{map[float64]int{1.5: 1}, "unusable as hash key: FLOAT"},
+		{func() {}, "cannot convert Go value of type func()"},
+	}
+
+	for _, tt := range errorTests {
+		_, err := FromGo(tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %#v. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestToGo(t *testing.T) {
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	hash.Set("a", &Integer{Value: 1})
+	hash.Set("b", &Array{Elements: []Object{&String{Value: "x"}, NULL}})
+
+	mixed := &Hash{Pairs: map[HashKey]HashPair{}}
+	mixed.Pairs[(&Integer{Value: 1}).HashKey()] = HashPair{Key: &Integer{Value: 1}, Value: TRUE}
+
+	var i int
+	var u8 uint8
+	var f float32
+	var s string
+	var b bool
+	var ints []int
+	var strs map[string]string
+	var ptr *int
+	var obj Object
+	var hashPtr *Hash
+	var natural interface{}
+	var naturalMixed interface{}
+
+	tests := []struct {
+		obj      Object
+		target   interface{}
+		expected interface{}
+	}{
+		{&Integer{Value: -5}, &i, -5},
+		{&Integer{Value: 200}, &u8, uint8(200)},
+		{&Integer{Value: 2}, &f, float32(2)},
+		{&String{Value: "hi"}, &s, "hi"},
+		{TRUE, &b, true},
+		{&Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}}}, &ints, []int{1, 2}},
+		{NULL, &ints, []int(nil)},
+		{&Hash{Pairs: map[HashKey]HashPair{}}, &strs, map[string]string{}},
+		{&Integer{Value: 4}, &ptr, func() *int { n := 4; return &n }()},
+		{&String{Value: "kept"}, &obj, Object(&String{Value: "kept"})},
+		{hash, &hashPtr, hash},
+		{hash, &natural, map[string]interface{}{"a": int64(1), "b": []interface{}{"x", nil}}},
+		{mixed, &naturalMixed, map[interface{}]interface{}{int64(1): true}},
+	}
+
+	for _, tt := range tests {
+		err := ToGo(tt.obj, tt.target)
+		if err != nil {
+			t.Errorf("unexpected error converting %s: %s", tt.obj.Inspect(), err)
+			continue
+		}
+
+		got := reflect.ValueOf(tt.target).Elem().Interface()
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("wrong conversion of %s. want=%#v, got=%#v", tt.obj.Inspect(), tt.expected, got)
+		}
+	}
+
+	errorTests := []struct {
+		obj      Object
+		target   interface{}
+		expected string
+	}{
+		{&String{Value: "1"}, &i, "cannot use STRING as int"},
+		{&Integer{Value: 256}, &u8, "256 overflows uint8"},
+		{&Integer{Value: -1}, &u8, "-1 overflows uint8"},
+		{&Array{Elements: []Object{TRUE}}, &ints, "cannot use BOOLEAN as int"},
+		{NULL, &s, "cannot use NULL as string"},
+		{&Integer{Value: 1}, i, "ToGo needs a non-nil pointer, got int"},
+	}
+
+	for _, tt := range errorTests {
+		err := ToGo(tt.obj, tt.target)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error converting %s. want=%q, got=%v", tt.obj.Inspect(), tt.expected, err)
+		}
+	}
+}
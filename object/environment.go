@@ -1,15 +1,20 @@
 package object
 
-import "bytes"
+import (
+	"bytes"
+	"sort"
+)
 
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store     map[string]Object
+	constants map[string]bool
+	outer     *Environment
 }
 
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s, outer: nil}
+	c := make(map[string]bool)
+	return &Environment{store: s, constants: c, outer: nil}
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -20,11 +25,53 @@ func (e *Environment) Get(name string) (Object, bool) {
 	return obj, ok
 }
 
+// Set binds name in this environment. Constants of this environment can't
+// be rebound, an error is returned instead.
 func (e *Environment) Set(name string, value Object) Object {
+	if e.constants[name] {
+		return &Error{Message: "cannot assign to constant " + name}
+	}
 	e.store[name] = value
 	return value
 }
 
+// SetConst binds name like Set and makes it a constant.
+func (e *Environment) SetConst(name string, value Object) Object {
+	result := e.Set(name, value)
+	if result.Type() != ERROR_OBJ {
+		e.constants[name] = true
+	}
+	return result
+}
+
+// Assign changes the closest binding of name, which may belong to an
+// enclosing environment.
+func (e *Environment) Assign(name string, value Object) Object {
+	if _, ok := e.store[name]; ok {
+		return e.Set(name, value)
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, value)
+	}
+	return &Error{Message: "identifier not found: " + name}
+}
+
+// IsConst reports whether name is a constant of this environment.
+func (e *Environment) IsConst(name string) bool {
+	return e.constants[name]
+}
+
+// Names returns the names bound in this environment, without the enclosing
+// ones, in sorted order.
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Debug purpose
 func (e *Environment) String() string {
 	var out bytes.Buffer
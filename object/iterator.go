@@ -0,0 +1,100 @@
+package object
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Iterator walks a snapshot of an array, string or hash taken when the
+// iteration starts, so changing the collection inside a for loop doesn't
+// change what the loop visits.
+//
+// Arrays are visited in index order with the index as key, strings one
+// character at a time with the character's index as key, and hashes in
+// sorted key order, see SortedPairs.
+type Iterator struct {
+	keys     []Object
+	values   []Object
+	elements []Object // what a single loop variable is bound to
+	index    int
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string {
+	return fmt.Sprintf("Iterator[%p]", it)
+}
+
+// NewIterator returns false if obj can't be iterated over.
+func NewIterator(obj Object) (*Iterator, bool) {
+	it := &Iterator{index: -1}
+
+	switch obj := obj.(type) {
+	case *Array:
+		it.values = append([]Object{}, obj.Elements...)
+		for i := range it.values {
+			it.keys = append(it.keys, &Integer{Value: int64(i)})
+		}
+		it.elements = it.values
+
+	case *String:
+		for _, ch := range obj.Value {
+			it.keys = append(it.keys, &Integer{Value: int64(len(it.keys))})
+			it.values = append(it.values, &String{Value: string(ch)})
+		}
+		it.elements = it.values
+
+	case *Hash:
+		for _, pair := range obj.SortedPairs() {
+			it.keys = append(it.keys, pair.Key)
+			it.values = append(it.values, pair.Value)
+		}
+		it.elements = it.keys
+
+	default:
+		return nil, false
+	}
+
+	return it, true
+}
+
+// Next advances the iterator and reports whether there was an element left.
+func (it *Iterator) Next() bool {
+	if it.index+1 >= len(it.keys) {
+		return false
+	}
+	it.index++
+	return true
+}
+
+func (it *Iterator) Key() Object     { return it.keys[it.index] }
+func (it *Iterator) Value() Object   { return it.values[it.index] }
+func (it *Iterator) Element() Object { return it.elements[it.index] }
+
+// SortedPairs returns the pairs ordered by key. Keys of different types are
+// grouped by type name, booleans sort false first, integers numerically and
+// strings lexically.
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i].Key, pairs[j].Key
+		if a.Type() != b.Type() {
+			return a.Type() < b.Type()
+		}
+
+		switch a := a.(type) {
+		case *Integer:
+			return a.Value < b.(*Integer).Value
+		case *String:
+			return a.Value < b.(*String).Value
+		case *Boolean:
+			return !a.Value && b.(*Boolean).Value
+		}
+		return false
+	})
+
+	return pairs
+}
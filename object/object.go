@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"hash/fnv"
 	"monkey/ast"
+	"monkey/code"
+	"monkey/token"
 	"strconv"
 	"strings"
 )
@@ -20,12 +22,27 @@ const (
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
 	ERROR_OBJ        = "ERROR"
 	FUNCTION_OBJ     = "FUNCTION"
 	STRING_OBJ       = "STRING"
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CLOSURE_OBJ           = "CLOSURE"
+	ITERATOR_OBJ          = "ITERATOR"
+	CELL_OBJ              = "CELL"
+)
+
+// The only true, false and null values. Both engines compare them by
+// identity, so values created outside of them must use these too.
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+	NULL  = &Null{}
 )
 
 type HashKey struct {
@@ -89,17 +106,120 @@ type ReturnValue struct {
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 
+// Break and Continue carry loop control flow out of nested blocks in the
+// evaluator, the same way ReturnValue does for return
+type Break struct{}
+
+func (b *Break) Inspect() string  { return "break" }
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+
+type Continue struct{}
+
+func (c *Continue) Inspect() string  { return "continue" }
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+
 type Error struct {
 	Message string
+	Kind    string         // the type seen by catch blocks, see AsHash
+	Pos     token.Position // where the error was raised, if known
+
+	// The function calls active when the error was raised, innermost first
+	Stack []StackFrame
+}
+
+type StackFrame struct {
+	Function string         // empty for anonymous functions
+	Pos      token.Position // where the function was called
 }
 
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
+	return "ERROR: " + e.Message
+}
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 
+// Error lets runtime errors be returned as Go errors by the VM
+func (e *Error) Error() string { return e.Message }
+
+// Errors raised by the interpreter itself have no kind, catch blocks see
+// them as RuntimeError. Thrown values are Error unless they say otherwise.
+const (
+	RUNTIME_ERROR = "RuntimeError"
+	THROWN_ERROR  = "Error"
+)
+
+// NewThrownError turns the value of a throw statement into an error. A hash
+// can set the "message" and "type" of the error, a hash received by a catch
+// block can be thrown again that way. Other values become the message.
+func NewThrownError(value Object) *Error {
+	err := &Error{Message: value.Inspect(), Kind: THROWN_ERROR}
+
+	switch value := value.(type) {
+	case *String:
+		err.Message = value.Value
+	case *Hash:
+		if message, ok := value.Get("message").(*String); ok {
+			err.Message = message.Value
+		}
+		if kind, ok := value.Get("type").(*String); ok {
+			err.Kind = kind.Value
+		}
+	}
+
+	return err
+}
+
+// AsHash returns the hash a catch block receives for the error
+func (e *Error) AsHash() *Hash {
+	kind := e.Kind
+	if kind == "" {
+		kind = RUNTIME_ERROR
+	}
+
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	hash.Set("message", &String{Value: e.Message})
+	hash.Set("type", &String{Value: kind})
+	return hash
+}
+
+// Traceback formats the error followed by the calls that led to it
+func (e *Error) Traceback() string {
+	var out bytes.Buffer
+
+	out.WriteString(e.Inspect())
+
+	for i := 0; i < len(e.Stack); {
+		frame := e.Stack[i]
+
+		// Recursion repeats the same frame, it's printed only once
+		repeated := 1
+		for i+repeated < len(e.Stack) && e.Stack[i+repeated] == frame {
+			repeated++
+		}
+
+		name := frame.Function
+		if name == "" {
+			name = "<anonymous>"
+		}
+
+		fmt.Fprintf(&out, "\n  in %s called at %s", name, frame.Pos)
+		if repeated > 1 {
+			fmt.Fprintf(&out, " (%d times)", repeated)
+		}
+
+		i += repeated
+	}
+
+	return out.String()
+}
+
 type Function struct {
 	Body       *ast.BlockStatement
 	Env        *Environment
 	Parameters []*ast.Identifier
+	Name       string // empty for anonymous functions
 }
 
 func (f *Function) Inspect() string {
@@ -188,3 +308,51 @@ func (h *Hash) Inspect() string {
 
 	return out.String()
 }
+
+// Get returns the value stored under a string key, or nil
+func (h *Hash) Get(key string) Object {
+	pair, ok := h.Pairs[(&String{Value: key}).HashKey()]
+	if !ok {
+		return nil
+	}
+	return pair.Value
+}
+
+// Set stores value under a string key
+func (h *Hash) Set(key string, value Object) {
+	k := &String{Value: key}
+	h.Pairs[k.HashKey()] = HashPair{Key: k, Value: value}
+}
+
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+	Name          string // empty for anonymous functions
+	Positions     code.Positions
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// Cell holds a local variable captured by a closure. The frame defining the
+// variable and every closure capturing it share the cell, so they all see
+// assignments to it.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return c.Value.Inspect() }
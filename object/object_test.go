@@ -1,6 +1,10 @@
 package object
 
-import "testing"
+import (
+	"monkey/token"
+	"strings"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "hello world"}
@@ -58,3 +62,80 @@ func TestIntegerHashKey(t *testing.T) {
 		t.Error("integers with twoerent content have same hash keys")
 	}
 }
+
+func TestHashSortedPairs(t *testing.T) {
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	keys := []Object{
+		&String{Value: "b"},
+		&Integer{Value: 10},
+		&Boolean{Value: true},
+		&String{Value: "a"},
+		&Integer{Value: -1},
+		&Boolean{Value: false},
+	}
+	for _, key := range keys {
+		hash.Pairs[key.(Hashable).HashKey()] = HashPair{Key: key, Value: &Null{}}
+	}
+
+	expected := []string{"false", "true", "-1", "10", "a", "b"}
+
+	pairs := hash.SortedPairs()
+	if len(pairs) != len(expected) {
+		t.Fatalf("wrong number of pairs. want=%d, got=%d", len(expected), len(pairs))
+	}
+
+	for i, want := range expected {
+		if got := pairs[i].Key.Inspect(); got != want {
+			t.Errorf("wrong key at %d. want=%s, got=%s", i, want, got)
+		}
+	}
+}
+
+func TestIterator(t *testing.T) {
+	array := &Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}}}
+
+	it, ok := NewIterator(array)
+	if !ok {
+		t.Fatalf("array is not iterable")
+	}
+
+	// The iterator works on a snapshot
+	array.Elements = append(array.Elements, &Integer{Value: 3})
+
+	var keys, values []string
+	for it.Next() {
+		keys = append(keys, it.Key().Inspect())
+		values = append(values, it.Value().Inspect())
+	}
+
+	if strings.Join(keys, ",") != "0,1" {
+		t.Errorf("wrong keys. got=%v", keys)
+	}
+	if strings.Join(values, ",") != "1,2" {
+		t.Errorf("wrong values. got=%v", values)
+	}
+
+	if _, ok := NewIterator(&Integer{Value: 1}); ok {
+		t.Errorf("integer should not be iterable")
+	}
+}
+
+func TestErrorTraceback(t *testing.T) {
+	err := &Error{
+		Message: "boom",
+		Pos:     token.Position{File: "a.wf", Line: 2, Column: 3},
+		Stack: []StackFrame{
+			{Function: "f", Pos: token.Position{File: "a.wf", Line: 4, Column: 5}},
+			{Function: "f", Pos: token.Position{File: "a.wf", Line: 4, Column: 5}},
+			{Function: "", Pos: token.Position{File: "a.wf", Line: 9, Column: 1}},
+		},
+	}
+
+	expected := "ERROR: a.wf:2:3: boom\n" +
+		"  in f called at a.wf:4:5 (2 times)\n" +
+		"  in <anonymous> called at a.wf:9:1"
+
+	if err.Traceback() != expected {
+		t.Errorf("wrong traceback. want=%q, got=%q", expected, err.Traceback())
+	}
+}
@@ -6,11 +6,15 @@ import (
 	"monkey/lexer"
 	"monkey/token"
 	"strconv"
+	"strings"
 )
 
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // =
+	LOGICAL_OR  // ||
+	LOGICAL_AND // &&
 	EQUALS      // ==
 	LESSGREATER // > or <
 	SUM         // +
@@ -25,13 +29,17 @@ var precedences = map[token.TokenType]int{
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.LT_EQ:    LESSGREATER,
+	token.GT_EQ:    LESSGREATER,
+	token.AND:      LOGICAL_AND,
+	token.OR:       LOGICAL_OR,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
 	token.MODULUS:  PRODUCT,
 	token.LPAREN:   CALL,
-	token.ASSIGN:   EQUALS,
+	token.ASSIGN:   ASSIGN,
 	token.LBRACKET: INDEX,
 }
 
@@ -47,6 +55,27 @@ type Parser struct {
 	curToken       token.Token
 	peekToken      token.Token
 	errors         []string
+
+	// the innermost loop enclosing the current token, numbered from 1 by
+	// loops. It is 0 outside of loops and inside function literals, so that
+	// break and continue can't escape a function.
+	loop  int
+	loops int
+
+	// set while parsing an expression whose value is used. Leaving a loop
+	// from there would leave the expression half evaluated.
+	inValue bool
+	// the next expression makes up a whole statement, an if or try there
+	// may use break and continue in its blocks
+	statementStart bool
+	// the break and continue statements parsed, to reject the ones of an if
+	// or try that turns out to be an operand after all
+	loopControls []loopControl
+}
+
+type loopControl struct {
+	loop  int
+	token token.Token
 }
 
 func (p *Parser) registerPrefix(token token.TokenType, fn prefixParseFn) {
@@ -94,6 +123,10 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Value = p.parseExpression(LOWEST)
 
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -112,8 +145,59 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	stmt := &ast.BreakStatement{Token: p.curToken}
+	p.checkLoopControl()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	stmt := &ast.ContinueStatement{Token: p.curToken}
+	p.checkLoopControl()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// checkLoopControl reports a break or continue that isn't directly part of
+// a loop's statements
+func (p *Parser) checkLoopControl() {
+	switch {
+	case p.loop == 0:
+		p.errors = append(p.errors, fmt.Sprintf("%s: %s outside of loop", p.curToken.Pos, p.curToken.Literal))
+	case p.inValue:
+		p.loopControlInExpression(p.curToken)
+	default:
+		p.loopControls = append(p.loopControls, loopControl{loop: p.loop, token: p.curToken})
+	}
+}
+
+func (p *Parser) loopControlInExpression(t token.Token) {
+	msg := fmt.Sprintf("%s: %s can't be used inside an expression", t.Pos, t.Literal)
+	p.errors = append(p.errors, msg)
+}
+
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	p.statementStart = true
 	stmt.Expression = p.parseExpression(LOWEST)
 
 	if p.peekTokenIs(token.SEMICOLON) {
@@ -123,18 +207,42 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	return stmt
 }
 
+func (p *Parser) parseIllegal() ast.Expression {
+	if strings.HasPrefix(p.curToken.Literal, "/*") {
+		msg := fmt.Sprintf("%s: unterminated block comment", p.curToken.Pos)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+
+	msg := fmt.Sprintf("%s: illegal token %q", p.curToken.Pos, p.curToken.Literal)
+	p.errors = append(p.errors, msg)
+	return nil
+}
+
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
+	msg := fmt.Sprintf("%s: no prefix parse function for %s found", p.curToken.Pos, t)
 	p.errors = append(p.errors, msg)
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
+	// Only an if or try making up a whole statement may leave a loop, any
+	// other expression is a value
+	statement := p.statementStart
+	p.statementStart = false
+
+	inValue := p.inValue
+	defer func() { p.inValue = inValue }()
+	if !statement {
+		p.inValue = true
+	}
+
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
 		p.noPrefixParseFnError(p.curToken.Type)
 		return nil
 	}
 
+	loopControls := len(p.loopControls)
 	leftExp := prefix()
 
 	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
@@ -143,6 +251,17 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 			return leftExp
 		}
 
+		// The statement's if or try is the left operand
+		if statement {
+			for _, control := range p.loopControls[loopControls:] {
+				if control.loop == p.loop {
+					p.loopControlInExpression(control.token)
+				}
+			}
+			statement = false
+			p.inValue = true
+		}
+
 		p.nextToken()
 
 		leftExp = infix(leftExp)
@@ -199,6 +318,15 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	if p.peekTokenIs(token.ELSE) {
 		p.nextToken()
 
+		if p.peekTokenIs(token.IF) {
+			p.nextToken()
+			expression.Alternative = p.parseElseIf()
+			if expression.Alternative == nil {
+				return nil
+			}
+			return expression
+		}
+
 		if !p.expectPeek(token.LBRACE) {
 			return nil
 		}
@@ -209,6 +337,22 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+// `else if (...) {...}` is parsed as an else block holding just the nested if
+// expression, so the evaluator and compiler handle chains like nested ifs
+func (p *Parser) parseElseIf() *ast.BlockStatement {
+	block := &ast.BlockStatement{Token: p.curToken}
+	stmt := &ast.ExpressionStatement{Token: p.curToken}
+
+	nested := p.parseIfExpression()
+	if nested == nil {
+		return nil
+	}
+	stmt.Expression = nested
+
+	block.Statements = []ast.Statement{stmt}
+	return block
+}
+
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.curToken}
 
@@ -222,7 +366,10 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
+	loop, inValue := p.loop, p.inValue
+	p.loop, p.inValue = 0, false
 	lit.Body = p.parseBlockStatement()
+	p.loop, p.inValue = loop, inValue
 
 	return lit
 }
@@ -262,7 +409,7 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 
 	for !p.curTokenIs(token.RBRACE) {
 		if p.curTokenIs(token.EOF) {
-			msg := "could not parse as block Statement"
+			msg := fmt.Sprintf("%s: could not parse as block Statement", p.curToken.Pos)
 			p.errors = append(p.errors, msg)
 			return nil
 		}
@@ -293,8 +440,107 @@ func (p *Parser) parseLoopExpression() ast.Expression {
 		return nil
 	}
 
+	expression.Body = p.parseLoopBody()
+
+	return expression
+}
+
+// parseLoopBody parses the block of a loop, where break and continue refer
+// to that loop
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	loop, inValue := p.loop, p.inValue
+
+	p.loops++
+	p.loop, p.inValue = p.loops, false
+	body := p.parseBlockStatement()
+
+	p.loop, p.inValue = loop, inValue
+	return body
+}
+
+func (p *Parser) parseForExpression() ast.Expression {
+	expression := &ast.ForExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	expression.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		expression.Key = expression.Value
+		expression.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	expression.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Body = p.parseLoopBody()
+
+	return expression
+}
+
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
 	expression.Body = p.parseBlockStatement()
 
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			expression.Param = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Finally = p.parseBlockStatement()
+	}
+
+	if expression.Catch == nil && expression.Finally == nil {
+		msg := fmt.Sprintf("%s: try without catch or finally", expression.Token.Pos)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+
 	return expression
 }
 
@@ -342,7 +588,7 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
+		msg := fmt.Sprintf("%s: could not parse %q as integer", p.curToken.Pos, p.curToken.Literal)
 		p.errors = append(p.errors, msg)
 		return nil
 	}
@@ -357,7 +603,7 @@ func (p *Parser) parseFloatLiteral() ast.Expression {
 
 	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
+		msg := fmt.Sprintf("%s: could not parse %q as float", p.curToken.Pos, p.curToken.Literal)
 		p.errors = append(p.errors, msg)
 		return nil
 	}
@@ -453,10 +699,16 @@ func (p *Parser) peekTokenIs(t token.TokenType) bool {
 
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
-	case token.LET:
+	case token.LET, token.CONST:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -479,7 +731,7 @@ func (p *Parser) Errors() []string {
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
+	msg := fmt.Sprintf("%s: expected next token to be %s, got %s instead", p.peekToken.Pos, t, p.peekToken.Type)
 	p.errors = append(p.errors, msg)
 }
 
@@ -498,9 +750,12 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.LOOP, p.parseLoopExpression)
+	p.registerPrefix(token.FOR, p.parseForExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBRACKET, p.parserArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -511,6 +766,10 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.MODULUS, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.ASSIGN, p.parseInfixExpression)
@@ -46,6 +46,43 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestConstStatements(t *testing.T) {
+	l := lexer.New("const x = 5; let y = 1;")
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("s not *ast.LetStatement. got=%T", program.Statements[0])
+	}
+
+	if stmt.TokenLiteral() != "const" {
+		t.Errorf("stmt.TokenLiteral not 'const'. got=%q", stmt.TokenLiteral())
+	}
+
+	if !stmt.IsConst() {
+		t.Errorf("stmt.IsConst() is false")
+	}
+
+	if stmt.Name.Value != "x" {
+		t.Errorf("stmt.Name.Value not x. got=%s", stmt.Name.Value)
+	}
+
+	if !testLiteralExpression(t, stmt.Value, 5) {
+		return
+	}
+
+	if program.Statements[1].(*ast.LetStatement).IsConst() {
+		t.Errorf("let statement is const")
+	}
+}
+
 func TestReturnStatement(t *testing.T) {
 	tests := []struct {
 		expectedValue interface{}
@@ -277,6 +314,10 @@ func TestParsingInfixExpression(t *testing.T) {
 		{5, 5, "5 > 5;", ">"},
 		{5, 5, "5 == 5;", "=="},
 		{5, 5, "5 != 5;", "!="},
+		{5, 5, "5 <= 5;", "<="},
+		{5, 5, "5 >= 5;", ">="},
+		{true, false, "true && false;", "&&"},
+		{true, false, "true || false;", "||"},
 		{10, 4, "10 % 4;", "%"},
 		{10, 3.21, "10 / 3.21", "/"},
 		{true, true, "true == true", "=="},
@@ -340,6 +381,11 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"add(a + b + c * d / f + g)", "add((((a + b) + ((c * d) / f)) + g))"},
 		{"a = b = c", "(a = (b = c))"},
 		{"a = 2 + b = 3", "(a = ((2 + b) = 3))"},
+		{"a >= 0 && a < n", "((a >= 0) && (a < n))"},
+		{"a || b && c", "(a || (b && c))"},
+		{"a && b || c && d", "((a && b) || (c && d))"},
+		{"a == b && c != d", "((a == b) && (c != d))"},
+		{"x = a || b", "(x = (a || b))"},
 		{"a * [1, 2, 3, 4][b * c] * d", "((a * ([1, 2, 3, 4][(b * c)])) * d)"},
 		{"add(a * b[2], b[1], 2 * [1, 2][1])", "add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))"},
 	}
@@ -566,6 +612,85 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestElseIfExpression(t *testing.T) {
+	input := `if (x < y) { x } else if (x > y) { y } else { z }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statement. got=%d\n", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+	}
+
+	exp, ok := stmt.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.IfExpression. got=%T", stmt.Expression)
+	}
+
+	if !testInfixExpresion(t, exp.Condition, "x", "<", "y") {
+		return
+	}
+
+	if exp.Alternative == nil || len(exp.Alternative.Statements) != 1 {
+		t.Fatalf("exp.Alternative does not contain 1 statement. got=%+v", exp.Alternative)
+	}
+
+	alternative, ok := exp.Alternative.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("Statements[0] is not ast.ExpressionStatement. got=%T", exp.Alternative.Statements[0])
+	}
+
+	nested, ok := alternative.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("alternative.Expression is not ast.IfExpression. got=%T", alternative.Expression)
+	}
+
+	if !testInfixExpresion(t, nested.Condition, "x", ">", "y") {
+		return
+	}
+
+	consequence, ok := nested.Consequence.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("Statements[0] is not ast.ExpressionStatement. got=%T", nested.Consequence.Statements[0])
+	}
+
+	if !testIdentifier(t, consequence.Expression, "y") {
+		return
+	}
+
+	if nested.Alternative == nil || len(nested.Alternative.Statements) != 1 {
+		t.Fatalf("nested.Alternative does not contain 1 statement. got=%+v", nested.Alternative)
+	}
+
+	last, ok := nested.Alternative.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("Statements[0] is not ast.ExpressionStatement. got=%T", nested.Alternative.Statements[0])
+	}
+
+	if !testIdentifier(t, last.Expression, "z") {
+		return
+	}
+}
+
+func TestElseIfExpressionErrors(t *testing.T) {
+	input := `if (x) { x } else if x { y }`
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+
+	if len(p.Errors()) == 0 {
+		t.Fatalf("expected parser errors, got none")
+	}
+}
+
 func TestFunctionLiteral(t *testing.T) {
 	input := `fn(x, y) { x + y }`
 
@@ -1006,3 +1131,234 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		testFunc(v)
 	}
 }
+
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = fn() { };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.LetStatement. got=%T", program.Statements[0])
+	}
+
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Value is not ast.FunctionLiteral. got=%T", stmt.Value)
+	}
+
+	if function.Name != "myFunction" {
+		t.Fatalf("function literal name wrong. want 'myFunction', got=%q", function.Name)
+	}
+}
+
+func TestForExpression(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedKey      string
+		expectedValue    string
+		expectedIterable string
+	}{
+		{"for (x in xs) { x }", "", "x", "xs"},
+		{"for (k, v in {1: 2}) { k }", "k", "v", "{1 : 2}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.ForExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.ForExpression. got=%T", stmt.Expression)
+		}
+
+		if tt.expectedKey == "" {
+			if exp.Key != nil {
+				t.Errorf("exp.Key is not nil. got=%s", exp.Key)
+			}
+		} else if !testIdentifier(t, exp.Key, tt.expectedKey) {
+			return
+		}
+
+		if !testIdentifier(t, exp.Value, tt.expectedValue) {
+			return
+		}
+
+		if exp.Iterable.String() != tt.expectedIterable {
+			t.Errorf("exp.Iterable is not %q. got=%q", tt.expectedIterable, exp.Iterable.String())
+		}
+
+		if len(exp.Body.Statements) != 1 {
+			t.Errorf("exp.Body.Statements does not contain 1 statement. got=%d", len(exp.Body.Statements))
+		}
+	}
+}
+
+func TestBreakContinueStatements(t *testing.T) {
+	input := `loop (true) { if (x) { break; } continue }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	loop, ok := stmt.Expression.(*ast.LoopExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.LoopExpression. got=%T", stmt.Expression)
+	}
+
+	if len(loop.Body.Statements) != 2 {
+		t.Fatalf("loop.Body.Statements does not contain 2 statements. got=%d", len(loop.Body.Statements))
+	}
+
+	ifStmt := loop.Body.Statements[0].(*ast.ExpressionStatement)
+	ifExp, ok := ifStmt.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("Statements[0] is not ast.IfExpression. got=%T", ifStmt.Expression)
+	}
+
+	if _, ok := ifExp.Consequence.Statements[0].(*ast.BreakStatement); !ok {
+		t.Fatalf("consequence is not ast.BreakStatement. got=%T", ifExp.Consequence.Statements[0])
+	}
+
+	if _, ok := loop.Body.Statements[1].(*ast.ContinueStatement); !ok {
+		t.Fatalf("Statements[1] is not ast.ContinueStatement. got=%T", loop.Body.Statements[1])
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedParam   string
+		expectedCatch   bool
+		expectedFinally bool
+	}{
+		{"try { x } catch (e) { e }", "e", true, false},
+		{"try { x } catch { 1 }", "", true, false},
+		{"try { x } finally { 1 }", "", false, true},
+		{"try { x } catch (err) { err } finally { 1 }", "err", true, true},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.TryExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.TryExpression. got=%T", stmt.Expression)
+		}
+
+		if len(exp.Body.Statements) != 1 {
+			t.Errorf("exp.Body.Statements does not contain 1 statement. got=%d", len(exp.Body.Statements))
+		}
+
+		if tt.expectedParam == "" {
+			if exp.Param != nil {
+				t.Errorf("exp.Param is not nil. got=%s", exp.Param)
+			}
+		} else if !testIdentifier(t, exp.Param, tt.expectedParam) {
+			return
+		}
+
+		if (exp.Catch != nil) != tt.expectedCatch {
+			t.Errorf("exp.Catch is wrong. want present=%t, got=%v", tt.expectedCatch, exp.Catch)
+		}
+
+		if (exp.Finally != nil) != tt.expectedFinally {
+			t.Errorf("exp.Finally is wrong. want present=%t, got=%v", tt.expectedFinally, exp.Finally)
+		}
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	l := lexer.New(`throw "boom";`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("stmt is not ast.ThrowStatement. got=%T", program.Statements[0])
+	}
+
+	if stmt.String() != "throw boom;" {
+		t.Errorf("stmt.String() wrong. got=%q", stmt.String())
+	}
+}
+
+func TestLoopControlInStatements(t *testing.T) {
+	tests := []string{
+		"loop (true) { if (x) { break } else if (y) { continue } }",
+		"loop (true) { try { break } finally { 1 } }",
+		"let x = loop (true) { break }",
+		"[loop (true) { if (x) { break } }]",
+		"loop (true) { if (x) { loop (true) { break } } + 1 }",
+		"loop (true) { let f = fn() { loop (true) { continue } } }",
+	}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := New(l)
+		p.ParseProgram()
+		checkParserErrors(t, p)
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"let = 5;", "test.wf:1:5: expected next token to be IDENT, got = instead"},
+		{"let a = 5;\nlet b 6;", "test.wf:2:7: expected next token to be =, got INT instead"},
+		{"if (x) {\n  x", "test.wf:2:4: could not parse as block Statement"},
+		{"let a = 1;\n/* never closed", "test.wf:2:1: unterminated block comment"},
+		{"let a = 1.2.3;", "test.wf:1:9: illegal token \"1.2.3\""},
+		{"break;", "test.wf:1:1: break outside of loop"},
+		{"loop (true) {}\ncontinue;", "test.wf:2:1: continue outside of loop"},
+		{"loop (true) { fn() { break; } }", "test.wf:1:22: break outside of loop"},
+		{"for (x y) { x }", "test.wf:1:8: expected next token to be IN, got IDENT instead"},
+		{"for (x in xs) { break; }; continue;", "test.wf:1:27: continue outside of loop"},
+		{"loop (true) { [1, if (true) { break }] }", "test.wf:1:31: break can't be used inside an expression"},
+		{"for (x in xs) { let y = if (x) { continue }; }", "test.wf:1:34: continue can't be used inside an expression"},
+		{"loop (true) { x = try { break } catch { 1 } }", "test.wf:1:25: break can't be used inside an expression"},
+		{"loop (true) { if (true) { break } + 1 }", "test.wf:1:27: break can't be used inside an expression"},
+		{"loop (true) { let y = if (true) { if (x) { break } } }", "test.wf:1:44: break can't be used inside an expression"},
+		{"loop (true) { f(loop (x) { 1 }, if (y) { break }) }", "test.wf:1:42: break can't be used inside an expression"},
+		{"try { x }", "test.wf:1:1: try without catch or finally"},
+		{"try { x } catch (1) { 2 }", "test.wf:1:18: expected next token to be IDENT, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewWithFile(tt.input, "test.wf")
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors for %q, got none", tt.input)
+		}
+
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong parser error. expected=%q, got=%q", tt.expectedError, errors[0])
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package repl
+
+import (
+	"fmt"
+	"monkey/compiler"
+	"monkey/disasm"
+	"os"
+	"strings"
+)
+
+type command struct {
+	name string
+	args string // shown in :help
+	help string
+	run  func(s *session, arg string)
+}
+
+var commands []command
+
+func init() {
+	// Assigned in init since :help refers to the list itself
+	commands = []command{
+		{"ast", "", "print the syntax tree of the last input", (*session).printAST},
+		{"bytecode", "", "print the bytecode of the last input", (*session).printBytecode},
+		{"globals", "", "print the global variables and their values", (*session).printGlobals},
+		{"engine", "[eval|vm]", "show or switch the engine, each has its own variables", (*session).switchEngine},
+		{"load", "file.wf", "run a file in this session", (*session).load},
+		{"reset", "", "forget all variables", (*session).resetCommand},
+		{"help", "", "show this help", (*session).printHelp},
+	}
+}
+
+// command runs a line starting with a colon, e.g. ":load file.wf"
+func (s *session) command(line string) {
+	name, arg, _ := strings.Cut(strings.TrimPrefix(line, ":"), " ")
+	arg = strings.TrimSpace(arg)
+
+	for _, c := range commands {
+		if c.name == name {
+			c.run(s, arg)
+			return
+		}
+	}
+
+	fmt.Fprintf(s.out, "unknown command :%s, see :help\n", name)
+}
+
+func (s *session) printAST(arg string) {
+	if s.program == nil {
+		fmt.Fprintln(s.out, "nothing to show yet, enter some code first")
+		return
+	}
+
+	for _, stmt := range s.program.Statements {
+		fmt.Fprintf(s.out, "%T %s\n", stmt, stmt.String())
+	}
+}
+
+func (s *session) printBytecode(arg string) {
+	if s.bytecode == nil {
+		if s.engine == "eval" {
+			fmt.Fprintln(s.out, "the eval engine doesn't compile to bytecode, see :engine")
+		} else {
+			fmt.Fprintln(s.out, "nothing to show yet, enter some code first")
+		}
+		return
+	}
+
+	d := disasm.New(s.bytecode, s.symbolTable, s.source)
+	d.SkipConstants(s.firstConstant)
+	d.Disassemble(s.out)
+}
+
+func (s *session) printGlobals(arg string) {
+	found := false
+
+	if s.engine == "eval" {
+		for _, name := range s.env.Names() {
+			value, _ := s.env.Get(name)
+			s.printGlobal(name, s.env.IsConst(name), value.Inspect())
+			found = true
+		}
+	} else {
+		for _, symbol := range s.symbolTable.Symbols() {
+			if symbol.Scope != compiler.GlobalScope {
+				continue
+			}
+
+			value := s.globals[symbol.Index]
+			if value == nil {
+				continue
+			}
+			s.printGlobal(symbol.Name, symbol.Constant, value.Inspect())
+			found = true
+		}
+	}
+
+	if !found {
+		fmt.Fprintln(s.out, "no globals defined")
+	}
+}
+
+func (s *session) printGlobal(name string, constant bool, value string) {
+	// Hidden variables of the compiler, such as a for loop's iterator
+	if strings.HasPrefix(name, "@") {
+		return
+	}
+
+	if constant {
+		fmt.Fprintf(s.out, "const %s = %s\n", name, value)
+	} else {
+		fmt.Fprintf(s.out, "%s = %s\n", name, value)
+	}
+}
+
+func (s *session) switchEngine(arg string) {
+	switch arg {
+	case "":
+	case "eval", "vm":
+		s.engine = arg
+		s.program = nil
+		s.bytecode = nil
+	default:
+		fmt.Fprintf(s.out, "unknown engine %q, want eval or vm\n", arg)
+		return
+	}
+
+	fmt.Fprintf(s.out, "engine: %s\n", s.engine)
+}
+
+func (s *session) load(arg string) {
+	if arg == "" {
+		fmt.Fprintln(s.out, "usage: :load file.wf")
+		return
+	}
+
+	src, err := os.ReadFile(arg)
+	if err != nil {
+		fmt.Fprintf(s.out, "could not read file: %s\n", err)
+		return
+	}
+
+	s.run(string(src), arg)
+}
+
+func (s *session) resetCommand(arg string) {
+	s.reset()
+	fmt.Fprintln(s.out, "session reset")
+}
+
+func (s *session) printHelp(arg string) {
+	for _, c := range commands {
+		usage := ":" + c.name
+		if c.args != "" {
+			usage += " " + c.args
+		}
+		fmt.Fprintf(s.out, "  %-22s %s\n", usage, c.help)
+	}
+}
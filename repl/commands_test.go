@@ -0,0 +1,60 @@
+package repl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "lib.wf")
+	err := os.WriteFile(file, []byte("let double = fn(x) { x * 2 };"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let a = 1;\nconst b = 2;\nfor (x in [1]) { x }\n:globals", []string{"a = 1\nconst b = 2\n>>"}},
+		{"let a = 1 + 2;\n:ast", []string{"*ast.LetStatement let a = (1 + 2);\n"}},
+		{"1 + 2\n:bytecode", []string{"0006 OpAdd\n", "   1 2\n"}},
+		{":engine eval\nlet a = 4;\nconst b = 5;\n:globals", []string{"engine: eval\n", "a = 4\nconst b = 5\n"}},
+		{":engine eval\n1\n:bytecode", []string{"the eval engine doesn't compile to bytecode"}},
+		{"let a = 1;\n:engine eval\n:globals", []string{"no globals defined\n"}},
+		{":engine js", []string{`unknown engine "js", want eval or vm`}},
+		{"let a = 1;\n:reset\n:globals\na", []string{"session reset\n", "no globals defined\n", "undefined variable a"}},
+		{":load " + file + "\ndouble(21)", []string{">>42\n"}},
+		{":engine eval\n:load " + file + "\ndouble(21)", []string{">>42\n"}},
+		{":load missing.wf", []string{"could not read file"}},
+		{":ast", []string{"nothing to show yet"}},
+		{":what", []string{"unknown command :what, see :help"}},
+		{":help", []string{":load file.wf", ":reset"}},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		Start(strings.NewReader(tt.input+"\n"), &out)
+
+		for _, expected := range tt.expected {
+			if !strings.Contains(out.String(), expected) {
+				t.Errorf("output of %q does not contain %q. got=%q", tt.input, expected, out.String())
+			}
+		}
+	}
+}
+
+func TestBytecodeOfLastInput(t *testing.T) {
+	var out bytes.Buffer
+	Start(strings.NewReader("let f = fn() { 1 };\n2 + 3\n:bytecode\n"), &out)
+
+	if !strings.Contains(out.String(), "== constants ==\n   2 2\n   3 3\n") {
+		t.Errorf("constants of the last input not shown. got=%q", out.String())
+	}
+	if strings.Contains(out.String(), "fn f") {
+		t.Errorf("function of an earlier input shown. got=%q", out.String())
+	}
+}
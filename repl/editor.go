@@ -0,0 +1,398 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// lineReader reads one line of input after showing a prompt. It returns
+// io.EOF once the input ends.
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+}
+
+// newLineReader edits lines in the terminal when in is one, and reads plain
+// lines otherwise, e.g. from a pipe.
+func newLineReader(in io.Reader, out io.Writer, names func() []string) lineReader {
+	if f, ok := in.(*os.File); ok && isTerminal(f.Fd()) {
+		e := newLineEditor(f, out, historyPath(), names)
+		e.raw = func() (func(), error) { return makeRaw(f.Fd()) }
+		return e
+	}
+
+	return &plainReader{scanner: bufio.NewScanner(in), out: out}
+}
+
+type plainReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (r *plainReader) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyCtrlH     = 8
+	keyTab       = 9
+	keyCtrlK     = 11
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+// Lines kept in the history file
+const historySize = 1000
+
+// lineEditor reads lines from a terminal in raw mode, with cursor movement,
+// history and tab completion. Keys follow the usual readline bindings.
+type lineEditor struct {
+	in  *bufio.Reader
+	out io.Writer
+	raw func() (func(), error) // switches the terminal to raw mode
+
+	history     []string
+	historyFile string // empty to keep the history in memory only
+	names       func() []string
+
+	// the line being edited
+	prompt       string
+	line         []rune
+	cursor       int
+	historyIndex int
+	pending      []rune // the new line while browsing the history
+}
+
+func newLineEditor(in io.Reader, out io.Writer, historyFile string, names func() []string) *lineEditor {
+	return &lineEditor{
+		in:          bufio.NewReader(in),
+		out:         out,
+		history:     loadHistory(historyFile),
+		historyFile: historyFile,
+		names:       names,
+	}
+}
+
+func (e *lineEditor) ReadLine(prompt string) (string, error) {
+	if e.raw != nil {
+		restore, err := e.raw()
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+	}
+
+	e.prompt = prompt
+	e.line = nil
+	e.cursor = 0
+	e.historyIndex = len(e.history)
+	e.refresh()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			line := string(e.line)
+			e.addHistory(line)
+			return line, nil
+
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			e.line = nil
+			e.cursor = 0
+			e.historyIndex = len(e.history)
+
+		case keyCtrlD:
+			if len(e.line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.deleteForward()
+
+		case keyBackspace, keyCtrlH:
+			if e.cursor > 0 {
+				e.line = append(e.line[:e.cursor-1], e.line[e.cursor:]...)
+				e.cursor--
+			}
+
+		case keyTab:
+			e.completeWord()
+
+		case keyCtrlA:
+			e.cursor = 0
+		case keyCtrlE:
+			e.cursor = len(e.line)
+		case keyCtrlB:
+			e.moveCursor(-1)
+		case keyCtrlF:
+			e.moveCursor(1)
+		case keyCtrlP:
+			e.moveHistory(-1)
+		case keyCtrlN:
+			e.moveHistory(1)
+
+		case keyCtrlK:
+			e.line = e.line[:e.cursor]
+		case keyCtrlU:
+			e.line = e.line[e.cursor:]
+			e.cursor = 0
+		case keyCtrlW:
+			start := e.cursor
+			for start > 0 && e.line[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && e.line[start-1] != ' ' {
+				start--
+			}
+			e.line = append(e.line[:start], e.line[e.cursor:]...)
+			e.cursor = start
+
+		case keyEscape:
+			err := e.escapeSequence()
+			if err != nil {
+				return "", err
+			}
+
+		default:
+			if unicode.IsPrint(r) {
+				e.line = append(e.line[:e.cursor], append([]rune{r}, e.line[e.cursor:]...)...)
+				e.cursor++
+			}
+		}
+
+		e.refresh()
+	}
+}
+
+// escapeSequence handles the keys sent as "ESC [ code", such as the arrows,
+// home, end and delete
+func (e *lineEditor) escapeSequence() error {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return err
+	}
+
+	code := ""
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return err
+		}
+		code += string(r)
+
+		// Sequences end with a letter or ~, e.g. "A" or "3~"
+		if r == '~' || unicode.IsLetter(r) {
+			break
+		}
+	}
+
+	switch code {
+	case "A":
+		e.moveHistory(-1)
+	case "B":
+		e.moveHistory(1)
+	case "C":
+		e.moveCursor(1)
+	case "D":
+		e.moveCursor(-1)
+	case "H", "1~", "7~":
+		e.cursor = 0
+	case "F", "4~", "8~":
+		e.cursor = len(e.line)
+	case "3~":
+		e.deleteForward()
+	}
+
+	return nil
+}
+
+// refresh redraws the line: \r returns to its start, ESC [K clears what is
+// left of the old one and ESC [nD moves the cursor back into place
+func (e *lineEditor) refresh() {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", e.prompt, string(e.line))
+	if back := len(e.line) - e.cursor; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+func (e *lineEditor) moveCursor(delta int) {
+	e.cursor += delta
+	if e.cursor < 0 {
+		e.cursor = 0
+	}
+	if e.cursor > len(e.line) {
+		e.cursor = len(e.line)
+	}
+}
+
+func (e *lineEditor) deleteForward() {
+	if e.cursor < len(e.line) {
+		e.line = append(e.line[:e.cursor], e.line[e.cursor+1:]...)
+	}
+}
+
+// moveHistory replaces the line with an older (-1) or newer (1) entry. Past
+// the newest entry is the line that was being typed.
+func (e *lineEditor) moveHistory(delta int) {
+	index := e.historyIndex + delta
+	if index < 0 || index > len(e.history) {
+		return
+	}
+
+	if e.historyIndex == len(e.history) {
+		e.pending = e.line
+	}
+	e.historyIndex = index
+
+	if index == len(e.history) {
+		e.line = e.pending
+	} else {
+		e.line = []rune(e.history[index])
+	}
+	e.cursor = len(e.line)
+}
+
+// completeWord completes the word before the cursor. With several matches
+// it completes their common prefix, or lists them if there is none.
+func (e *lineEditor) completeWord() {
+	start := e.cursor
+	for start > 0 && isIdentifierRune(e.line[start-1]) {
+		start--
+	}
+	// Commands are completed with their colon
+	if start == 1 && e.line[0] == ':' {
+		start = 0
+	}
+
+	word := string(e.line[start:e.cursor])
+	if word == "" {
+		return
+	}
+
+	matches := []string{}
+	seen := map[string]bool{}
+	for _, name := range e.names() {
+		if strings.HasPrefix(name, word) && !seen[name] {
+			matches = append(matches, name)
+			seen[name] = true
+		}
+	}
+	sort.Strings(matches)
+
+	if len(matches) == 0 {
+		fmt.Fprint(e.out, "\a")
+		return
+	}
+
+	prefix := commonPrefix(matches)
+	if prefix == word && len(matches) > 1 {
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(matches, "  "))
+		return
+	}
+
+	rest := []rune(prefix[len(word):])
+	e.line = append(e.line[:e.cursor], append(rest, e.line[e.cursor:]...)...)
+	e.cursor += len(rest)
+}
+
+func isIdentifierRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+func (e *lineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+
+	e.history = append(e.history, line)
+
+	if e.historyFile == "" {
+		return
+	}
+
+	// History is best effort, the REPL works without it
+	f, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+// historyPath returns where the history is saved, or an empty string if
+// there is no home directory to save it in
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".waffle_history")
+}
+
+// loadHistory reads the last historySize lines of the history file, and
+// trims the file to them.
+func loadHistory(path string) []string {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) > historySize {
+		lines = lines[len(lines)-historySize:]
+		os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	}
+
+	history := []string{}
+	for _, line := range lines {
+		if line != "" {
+			history = append(history, line)
+		}
+	}
+	return history
+}
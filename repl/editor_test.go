@@ -0,0 +1,151 @@
+package repl
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLineEditor(t *testing.T) {
+	names := func() []string {
+		return []string{"let", "len", "length", "last", "puts", "counter", ":load", ":help"}
+	}
+
+	tests := []struct {
+		keys     string
+		expected string
+	}{
+		{"let a = 1\r", "let a = 1"},
+		{"ac\x1b[Db\r", "abc"},
+		{"abc\x7f\x7fx\r", "ax"},
+		{"bc\x01a\x05d\r", "abcd"},
+		{"abc\x1b[H\x1b[3~\r", "bc"},
+		{"abc\x02\x02\x0b\r", "a"},
+		{"abc def\x17\r", "abc "},
+		{"abc def\x02\x02\x15\r", "ef"},
+		{"abc\x03xyz\r", "xyz"},
+		{"a\x1bOHb\x1b[F\x1b[1~c\r", "cba"},
+		{"pu\tx\r", "putsx"},
+		{"le\tn\r", "len"},
+		{"leng\t\r", "length"},
+		{"co\t = 1\r", "counter = 1"},
+		{":lo\t\r", ":load"},
+		{"lex\tt\r", "lext"},
+		{"a\x04\x01\x04\r", ""},
+		{"héllo\x1b[D\x7f\r", "hélo"},
+	}
+
+	for _, tt := range tests {
+		e := newLineEditor(strings.NewReader(tt.keys), io.Discard, "", names)
+
+		line, err := e.ReadLine(">>")
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", tt.keys, err)
+			continue
+		}
+
+		if line != tt.expected {
+			t.Errorf("wrong line for keys %q. want=%q, got=%q", tt.keys, tt.expected, line)
+		}
+	}
+}
+
+func TestLineEditorCompletionList(t *testing.T) {
+	names := func() []string { return []string{"len", "let", "last"} }
+
+	var out bytes.Buffer
+	e := newLineEditor(strings.NewReader("l\t\r"), &out, "", names)
+
+	line, err := e.ReadLine(">>")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if line != "l" {
+		t.Errorf("wrong line. want=%q, got=%q", "l", line)
+	}
+
+	if !strings.Contains(out.String(), "\r\nlast  len  let\r\n") {
+		t.Errorf("matches are not listed. got=%q", out.String())
+	}
+}
+
+func TestLineEditorEOF(t *testing.T) {
+	e := newLineEditor(strings.NewReader("\x04"), io.Discard, "", nil)
+	_, err := e.ReadLine(">>")
+	if err != io.EOF {
+		t.Errorf("expected io.EOF for ctrl-d, got=%v", err)
+	}
+
+	e = newLineEditor(strings.NewReader("abc"), io.Discard, "", nil)
+	_, err = e.ReadLine(">>")
+	if err != io.EOF {
+		t.Errorf("expected io.EOF at the end of input, got=%v", err)
+	}
+}
+
+func TestLineEditorHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	err := os.WriteFile(file, []byte("old\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := strings.Join([]string{
+		"one\r",
+		"two\r",
+		"two\r",
+		"\r",
+		"\x1b[A\x1b[A\r",                // one
+		"new\x1b[A\x1b[B\r",             // back to the line being typed
+		"\x10\x10\x10\x10\x10\r",        // stops at the oldest entry
+		"\x1b[A\x1b[A\x1b[A\x0e\x7f!\r", // edits a history entry
+	}, "")
+
+	e := newLineEditor(strings.NewReader(keys), io.Discard, file, nil)
+
+	expected := []string{"one", "two", "two", "", "one", "new", "old", "ne!"}
+	for _, want := range expected {
+		line, err := e.ReadLine(">>")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if line != want {
+			t.Errorf("wrong line. want=%q, got=%q", want, line)
+		}
+	}
+
+	wantHistory := []string{"old", "one", "two", "one", "new", "old", "ne!"}
+	if !reflect.DeepEqual(e.history, wantHistory) {
+		t.Errorf("wrong history. want=%q, got=%q", wantHistory, e.history)
+	}
+
+	if loaded := loadHistory(file); !reflect.DeepEqual(loaded, wantHistory) {
+		t.Errorf("wrong saved history. want=%q, got=%q", wantHistory, loaded)
+	}
+}
+
+func TestLoadHistoryTrims(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+
+	lines := []string{}
+	for i := 0; i < historySize+5; i++ {
+		lines = append(lines, strings.Repeat("x", i%7+1))
+	}
+	err := os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := loadHistory(file)
+	if len(history) != historySize {
+		t.Fatalf("wrong history length. want=%d, got=%d", historySize, len(history))
+	}
+
+	if reloaded := loadHistory(file); !reflect.DeepEqual(reloaded, history) {
+		t.Errorf("history file was not trimmed")
+	}
+}
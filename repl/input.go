@@ -0,0 +1,107 @@
+package repl
+
+import (
+	"monkey/lexer"
+	"monkey/token"
+)
+
+// Tokens that can't end a complete statement, the expression continues on
+// the next line
+var continuationTokens = map[token.TokenType]bool{
+	token.ASSIGN:   true,
+	token.PLUS:     true,
+	token.MINUS:    true,
+	token.BANG:     true,
+	token.ASTERISK: true,
+	token.SLASH:    true,
+	token.MODULUS:  true,
+	token.LT:       true,
+	token.GT:       true,
+	token.LT_EQ:    true,
+	token.GT_EQ:    true,
+	token.EQ:       true,
+	token.NOT_EQ:   true,
+	token.AND:      true,
+	token.OR:       true,
+	token.COMMA:    true,
+	token.COLON:    true,
+	token.ELSE:     true,
+}
+
+// incomplete reports whether more lines are needed before input can be
+// parsed: a bracket, string or block comment is still open, or the last
+// token is an operator waiting for its right side.
+func incomplete(input string) bool {
+	return unclosed(input) || continuationTokens[lastToken(input).Type]
+}
+
+// unclosed reports whether a bracket, string or block comment of input is
+// still open
+func unclosed(input string) bool {
+	depth := 0
+
+	for i := 0; i < len(input); i++ {
+		switch input[i] {
+		case '(', '{', '[':
+			depth++
+		case ')', '}', ']':
+			depth--
+		case '"':
+			i = skipString(input, i)
+			if i == len(input) {
+				return true
+			}
+		case '/':
+			if i+1 < len(input) && input[i+1] == '/' {
+				for i < len(input) && input[i] != '\n' {
+					i++
+				}
+			} else if i+1 < len(input) && input[i+1] == '*' {
+				end := skipBlockComment(input, i)
+				if end == len(input) {
+					return true
+				}
+				i = end
+			}
+		}
+	}
+
+	// Too many closing brackets can't be fixed by more input, the parser
+	// reports them
+	return depth > 0
+}
+
+// skipString returns the index of the quote closing the string opened at
+// start, or len(input) if it isn't closed
+func skipString(input string, start int) int {
+	for i := start + 1; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return len(input)
+}
+
+// skipBlockComment returns the index of the / closing the comment opened at
+// start, or len(input) if it isn't closed
+func skipBlockComment(input string, start int) int {
+	for i := start + 2; i+1 < len(input); i++ {
+		if input[i] == '*' && input[i+1] == '/' {
+			return i + 1
+		}
+	}
+	return len(input)
+}
+
+func lastToken(input string) token.Token {
+	l := lexer.New(input)
+
+	last := token.Token{Type: token.EOF}
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		last = tok
+	}
+	return last
+}
@@ -0,0 +1,73 @@
+package repl
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"let a = 1;", false},
+		{"", false},
+		{"let f = fn(x) {", true},
+		{"let f = fn(x) {\n  x\n}", false},
+		{"if (x > 10) {\n  puts(1);\n} else {", true},
+		{"if (x > 10) {\n  puts(1);\n} else", true},
+		{"puts(1,", true},
+		{"[1, 2", true},
+		{"{\"a\": 1", true},
+		{"let a = 1 +", true},
+		{"let a = 1 ||", true},
+		{"let a =", true},
+		{"let s = \"abc", true},
+		{"let s = \"a{c\"", false},
+		{"let s = \"a\\\"{\"", false},
+		{"let a = 1; // {", false},
+		{"let a = 1; /* {", true},
+		{"let a = 1; /* { */", false},
+		{"let a = 1 }", false},
+		{"a--", true},
+	}
+
+	for _, tt := range tests {
+		if got := incomplete(tt.input); got != tt.expected {
+			t.Errorf("incomplete(%q) wrong. want=%t, got=%t", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestReadInput(t *testing.T) {
+	tests := []struct {
+		lines    string
+		expected []string
+	}{
+		{"let a = 1;\nlet b = 2;", []string{"let a = 1;", "let b = 2;"}},
+		{"let f = fn(x) {\n\n  x\n};\nf(1)", []string{"let f = fn(x) {\n\n  x\n};", "f(1)"}},
+		{"let f = fn(x) {\n\n\nf(1)", []string{"let f = fn(x) {\n", "f(1)"}},
+		{"let a =\n\n1", []string{"let a =", "1"}},
+		{"let a = [\n1,", []string{"let a = [\n1,"}},
+		{":globals\n{", []string{":globals", "{"}},
+	}
+
+	for _, tt := range tests {
+		reader := &plainReader{scanner: bufio.NewScanner(strings.NewReader(tt.lines)), out: io.Discard}
+
+		inputs := []string{}
+		for {
+			input, ok := readInput(reader)
+			if !ok {
+				break
+			}
+			inputs = append(inputs, input)
+		}
+
+		if strings.Join(inputs, "|") != strings.Join(tt.expected, "|") {
+			t.Errorf("wrong inputs for %q. want=%q, got=%q", tt.lines, tt.expected, inputs)
+		}
+	}
+}
@@ -1,69 +1,211 @@
 package repl
 
 import (
-	"bufio"
 	"fmt"
 	"io"
+	"monkey/ast"
 	"monkey/compiler"
+	"monkey/evaluator"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"monkey/token"
 	"monkey/vm"
+	"strings"
 )
 
 const PROMPT = ">>"
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	// env := object.NewEnvironment()
+// CONTINUATION_PROMPT is shown while the input so far is incomplete. An
+// empty line ends the input anyway, or two in a row while a bracket, string
+// or comment is still open.
+const CONTINUATION_PROMPT = ".."
+
+// session holds what the REPL remembers between inputs. Each engine keeps
+// its own state, switching engines doesn't carry variables over.
+type session struct {
+	out    io.Writer
+	engine string
+
+	// vm engine
+	constants   []object.Object
+	globals     []object.Object
+	symbolTable *compiler.SymbolTable
+
+	// eval engine
+	env *object.Environment
 
-	constants := []object.Object{}
-	globals := make([]object.Object, vm.GLOBALSSIZE)
-	symbolTable := compiler.NewSymbolTable()
+	// the last input, for :ast and :bytecode. The constants before
+	// firstConstant were compiled from earlier inputs.
+	program       *ast.Program
+	bytecode      *compiler.Bytecode
+	source        string
+	firstConstant int
+}
+
+func newSession(out io.Writer) *session {
+	s := &session{out: out, engine: "vm"}
+	s.reset()
+	return s
+}
+
+func (s *session) reset() {
+	s.constants = []object.Object{}
+	s.globals = make([]object.Object, vm.GLOBALSSIZE)
+	s.symbolTable = compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		s.symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	s.env = object.NewEnvironment()
+
+	s.program = nil
+	s.bytecode = nil
+	s.source = ""
+	s.firstConstant = 0
+}
+
+func Start(in io.Reader, out io.Writer) {
+	s := newSession(out)
+	reader := newLineReader(in, out, s.completions)
 
 	for {
-		fmt.Fprintf(out, "%s", PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		input, ok := readInput(reader)
+		if !ok {
 			return
 		}
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
 
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		if strings.HasPrefix(strings.TrimSpace(input), ":") {
+			s.command(strings.TrimSpace(input))
 			continue
 		}
 
-		/* evaluated := evaluator.Eval(program, env)
+		s.run(input, "")
+	}
+}
+
+// run executes input with the current engine and prints its value. file
+// names the input in error positions, it is empty for typed input.
+func (s *session) run(input string, file string) {
+	l := lexer.NewWithFile(input, file)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, p.Errors())
+		return
+	}
+
+	s.program = program
+	s.bytecode = nil
+	s.source = input
+
+	if s.engine == "eval" {
+		evaluated := evaluator.Eval(program, s.env)
+		if err, ok := evaluated.(*object.Error); ok {
+			fmt.Fprintf(s.out, "Woops! Evaluation failed:\n %s\n", err.Traceback())
+			return
+		}
 		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
-		} */
+			io.WriteString(s.out, evaluated.Inspect())
+			io.WriteString(s.out, "\n")
+		}
+		return
+	}
 
-		comp := compiler.NewWithState(symbolTable, constants)
-		err := comp.Compile(program)
-		if err != nil {
-			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
-			continue
+	// Input that doesn't compile must not leave names behind, so they are
+	// defined in a copy of the symbol table
+	symbolTable := s.symbolTable.Copy()
+	comp := compiler.NewWithState(symbolTable, s.constants)
+	err := comp.Compile(program)
+	if err != nil {
+		fmt.Fprintf(s.out, "Woops! Compilation failed:\n %s\n", err)
+		return
+	}
+
+	code := comp.Bytecode()
+	s.symbolTable = symbolTable
+	s.firstConstant = len(s.constants)
+	s.constants = code.Constants
+	s.bytecode = code
+
+	machine := vm.NewWithGlobalStore(code, s.globals)
+	err = machine.Run()
+	if err != nil {
+		// Variables the input didn't get to set are null from now on
+		for _, symbol := range s.symbolTable.Symbols() {
+			if symbol.Scope == compiler.GlobalScope && s.globals[symbol.Index] == nil {
+				s.globals[symbol.Index] = vm.Null
+			}
 		}
+		fmt.Fprintf(s.out, "Woops! Executing Bytecode failed:\n %s\n", err.(*object.Error).Traceback())
+		return
+	}
 
-		code := comp.Bytecode()
-		constants = code.Constants
+	stackTop := machine.LastPopppedStackElem()
+	io.WriteString(s.out, stackTop.Inspect())
+	io.WriteString(s.out, "\n")
+}
+
+// readInput reads lines until they form a complete input. It returns false
+// once the input ends.
+func readInput(reader lineReader) (string, bool) {
+	input, err := reader.ReadLine(PROMPT)
+	if err != nil {
+		return "", false
+	}
 
-		machine := vm.NewWithGlobalStore(code, globals)
-		err = machine.Run()
+	// Commands are always a single line
+	if strings.HasPrefix(strings.TrimSpace(input), ":") {
+		return input, true
+	}
+
+	blank := false
+	for incomplete(input) {
+		line, err := reader.ReadLine(CONTINUATION_PROMPT)
 		if err != nil {
-			fmt.Fprintf(out, "Woops! Executing Bytecode failed:\n %s\n", err)
-			continue
+			return input, true
 		}
 
-		stackTop := machine.LastPopppedStackElem()
-		io.WriteString(out, stackTop.Inspect())
-		io.WriteString(out, "\n")
+		// Blank lines can separate the statements of an open block
+		if strings.TrimSpace(line) == "" {
+			if blank || !unclosed(input) {
+				break
+			}
+			blank = true
+		} else {
+			blank = false
+		}
+		input += "\n" + line
+	}
+
+	return input, true
+}
+
+// completions returns the names tab completion offers: keywords, builtins,
+// the session's variables and the commands
+func (s *session) completions() []string {
+	names := token.Keywords()
+
+	for _, def := range object.Builtins {
+		names = append(names, def.Name)
 	}
+
+	if s.engine == "eval" {
+		names = append(names, s.env.Names()...)
+	} else {
+		for _, symbol := range s.symbolTable.Symbols() {
+			if symbol.Scope == compiler.GlobalScope && !strings.HasPrefix(symbol.Name, "@") {
+				names = append(names, symbol.Name)
+			}
+		}
+	}
+
+	for _, c := range commands {
+		names = append(names, ":"+c.name)
+	}
+
+	return names
 }
 
 const WAFFLE = `            
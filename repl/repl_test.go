@@ -0,0 +1,28 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFailedInputs(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let y = 1; nope\ny", []string{"undefined variable nope", "undefined variable y"}},
+		{"let x = 1 + true;\nx", []string{"Executing Bytecode failed", ">>null\n"}},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		Start(strings.NewReader(tt.input+"\n"), &out)
+
+		for _, expected := range tt.expected {
+			if !strings.Contains(out.String(), expected) {
+				t.Errorf("output of %q does not contain %q. got=%q", tt.input, expected, out.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package vm
+
+import (
+	"monkey/code"
+	"monkey/object"
+	"monkey/token"
+)
+
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int // Stack pointer before the call, locals live above it
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// Position of the instruction being executed
+func (f *Frame) Position() token.Position {
+	return f.cl.Fn.Positions.Lookup(f.ip)
+}
@@ -11,22 +11,35 @@ import (
 const (
 	STACKSIZE   = 2048
 	GLOBALSSIZE = 65536
+	MAXFRAMES   = 1024
 )
 
 var (
-	True  = &object.Boolean{Value: true}
-	False = &object.Boolean{Value: false}
-	Null  = &object.Null{}
+	True  = object.TRUE
+	False = object.FALSE
+	Null  = object.NULL
 )
 
 type VM struct {
-	constants    []object.Object
-	instructions code.Instructions
+	constants []object.Object
 
 	stack []object.Object
 	sp    int // Always point to next value (top = stack[sp - 1])
 
 	globals []object.Object
+
+	frames      []*Frame
+	framesIndex int
+
+	handlers []handler
+}
+
+// An active try. Errors raised while it is active unwind the frames and
+// the stack back to where the try started and continue at ip.
+type handler struct {
+	framesIndex int
+	sp          int
+	ip          int
 }
 
 func nativeBooleanObject(input bool) *object.Boolean {
@@ -37,12 +50,24 @@ func nativeBooleanObject(input bool) *object.Boolean {
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Positions:    bytecode.Positions,
+		NumLocals:    bytecode.NumLocals,
+	}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MAXFRAMES)
+	frames[0] = mainFrame
+
 	return &VM{
-		instructions: bytecode.Instructions,
-		constants:    bytecode.Constants,
-		stack:        make([]object.Object, STACKSIZE),
-		sp:           0,
-		globals:      make([]object.Object, GLOBALSSIZE),
+		constants:   bytecode.Constants,
+		stack:       make([]object.Object, STACKSIZE),
+		sp:          mainFn.NumLocals,
+		globals:     make([]object.Object, GLOBALSSIZE),
+		frames:      frames,
+		framesIndex: 1,
 	}
 }
 
@@ -56,13 +81,90 @@ func (vm *VM) LastPopppedStackElem() object.Object {
 	return vm.stack[vm.sp]
 }
 
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// Run executes the program. Errors are returned as *object.Error located
+// at the failing instruction, with the calls that were active.
 func (vm *VM) Run() error {
-	for ip := 0; ip < len(vm.instructions); ip++ {
-		op := code.Opcode(vm.instructions[ip])
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+
+		runtimeErr := vm.runtimeError(err)
+		if len(vm.handlers) == 0 {
+			return runtimeErr
+		}
+
+		h := vm.handlers[len(vm.handlers)-1]
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		vm.framesIndex = h.framesIndex
+		vm.sp = h.sp
+		err = vm.push(runtimeErr)
+		if err != nil {
+			return vm.runtimeError(err)
+		}
+
+		// run increments ip before executing
+		vm.currentFrame().ip = h.ip - 1
+	}
+}
+
+func (vm *VM) runtimeError(err error) *object.Error {
+	runtimeErr, ok := err.(*object.Error)
+	if !ok {
+		runtimeErr = &object.Error{Message: err.Error()}
+	}
+
+	// Errors rethrown by a finally block keep where they were raised
+	if runtimeErr.Pos.IsValid() || len(runtimeErr.Stack) > 0 {
+		return runtimeErr
+	}
+
+	frames := vm.frames[:vm.framesIndex]
+	runtimeErr.Pos = frames[len(frames)-1].Position()
+
+	// Every frame but the main one is a call made from the frame below
+	for i := len(frames) - 1; i > 0; i-- {
+		runtimeErr.Stack = append(runtimeErr.Stack, object.StackFrame{
+			Function: frames[i].cl.Fn.Name,
+			Pos:      frames[i-1].Position(),
+		})
+	}
+
+	return runtimeErr
+}
+
+func (vm *VM) run() error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
 		switch op {
 		case code.OpConstant:
-			constIdx := code.ReadUint16(vm.instructions[ip+1:])
-			ip += 2
+			constIdx := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
 			err := vm.push(vm.constants[constIdx])
 			if err != nil {
 				return err
@@ -74,7 +176,7 @@ func (vm *VM) Run() error {
 				return err
 			}
 
-		case code.OpEqual, code.OpGreaterThan, code.OpNotEqual:
+		case code.OpEqual, code.OpGreaterThan, code.OpGreaterThanEqual, code.OpLessThan, code.OpLessThanEqual, code.OpNotEqual:
 			err := vm.executeComparison(op)
 			if err != nil {
 				return err
@@ -108,16 +210,16 @@ func (vm *VM) Run() error {
 			vm.pop()
 
 		case code.OpJump:
-			pos := int(code.ReadUint16(vm.instructions[ip+1:]))
-			ip = pos - 1
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
 
 		case code.OpJumpNotTruthy:
-			pos := int(code.ReadUint16(vm.instructions[ip+1:]))
-			ip += 2
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
 
 			condition := vm.pop()
 			if !isTruthy(condition) {
-				ip = pos - 1
+				vm.currentFrame().ip = pos - 1
 			}
 
 		case code.OpNull:
@@ -127,24 +229,524 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpSetGlobal:
-			globalIndex := code.ReadUint16(vm.instructions[ip+1:])
-			ip += 2
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
 
 			vm.globals[globalIndex] = vm.pop()
 
 		case code.OpGetGlobal:
-			globalIndex := code.ReadUint16(vm.instructions[ip+1:])
-			ip += 2
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
 
 			err := vm.push(vm.globals[globalIndex])
 			if err != nil {
 				return err
 			}
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+
+			err := vm.push(array)
+			if err != nil {
+				return err
+			}
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+
+			err = vm.push(hash)
+			if err != nil {
+				return err
+			}
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeIndexExpression(left, index)
+			if err != nil {
+				return err
+			}
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.executeCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			// A return at the top level ends the program, the popped value
+			// stays at stack[sp] as the last popped element
+			if vm.framesIndex == 1 {
+				return nil
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+			vm.dropHandlers()
+
+			err := vm.push(returnValue)
+			if err != nil {
+				return err
+			}
+
+		case code.OpReturn:
+			if vm.framesIndex == 1 {
+				return nil
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+			vm.dropHandlers()
+
+			err := vm.push(Null)
+			if err != nil {
+				return err
+			}
+
+		case code.OpDefineLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			// Replaces the cell of an earlier binding instead of changing it
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			setVariable(&vm.stack[frame.basePointer+int(localIndex)], vm.pop())
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			err := vm.push(variableValue(vm.stack[frame.basePointer+int(localIndex)]))
+			if err != nil {
+				return err
+			}
+
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			// The local moves into a cell the first time a closure captures it
+			frame := vm.currentFrame()
+			slot := &vm.stack[frame.basePointer+int(localIndex)]
+			cell, ok := (*slot).(*object.Cell)
+			if !ok {
+				cell = &object.Cell{Value: *slot}
+				*slot = cell
+			}
+
+			err := vm.push(cell)
+			if err != nil {
+				return err
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			err := vm.pushClosure(int(constIndex), int(numFree))
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(variableValue(currentClosure.Free[freeIndex]))
+			if err != nil {
+				return err
+			}
+
+		case code.OpCaptureFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(currentClosure.Free[freeIndex])
+			if err != nil {
+				return err
+			}
+
+		case code.OpCurrentClosure:
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(currentClosure)
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			setVariable(&currentClosure.Free[freeIndex], vm.pop())
+
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+
+		case code.OpIter:
+			iterable := vm.pop()
+
+			iterator, ok := object.NewIterator(iterable)
+			if !ok {
+				return fmt.Errorf("cannot iterate over %s", iterable.Type())
+			}
+
+			err := vm.push(iterator)
+			if err != nil {
+				return err
+			}
+
+		case code.OpIterNext:
+			iterator, err := vm.popIterator()
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(nativeBooleanObject(iterator.Next()))
+			if err != nil {
+				return err
+			}
+
+		case code.OpIterValues:
+			numValues := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			iterator, err := vm.popIterator()
+			if err != nil {
+				return err
+			}
+
+			err = vm.pushIteratorValues(iterator, int(numValues))
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetupTry:
+			catchPos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			vm.handlers = append(vm.handlers, handler{
+				framesIndex: vm.framesIndex,
+				sp:          vm.sp,
+				ip:          catchPos,
+			})
+
+		case code.OpEndTry:
+			if len(vm.handlers) == 0 || vm.handlers[len(vm.handlers)-1].framesIndex != vm.framesIndex {
+				return fmt.Errorf("no try to end")
+			}
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpCatch:
+			value := vm.pop()
+			caught, ok := value.(*object.Error)
+			if !ok {
+				return fmt.Errorf("cannot catch %s", value.Type())
+			}
+
+			err := vm.push(caught.AsHash())
+			if err != nil {
+				return err
+			}
+
+		case code.OpThrow:
+			value := vm.pop()
+			if err, ok := value.(*object.Error); ok {
+				return err
+			}
+			return object.NewThrownError(value)
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			definition := object.Builtins[builtinIndex]
+			err := vm.push(definition.Builtin)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
+// variableValue returns the value of a local or free variable, which is
+// kept in a cell once a closure captured it
+func variableValue(variable object.Object) object.Object {
+	if cell, ok := variable.(*object.Cell); ok {
+		return cell.Value
+	}
+	return variable
+}
+
+// setVariable assigns to a local or free variable, through its cell if it
+// has one so that every closure sharing it sees the new value
+func setVariable(variable *object.Object, value object.Object) {
+	if cell, ok := (*variable).(*object.Cell); ok {
+		cell.Value = value
+		return
+	}
+	*variable = value
+}
+
+// dropHandlers removes any handler left by a frame that returned, so an
+// error can't unwind into a frame that no longer exists
+func (vm *VM) dropHandlers() {
+	for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].framesIndex > vm.framesIndex {
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	}
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("calling non-function")
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	fn := cl.Fn
+	if numArgs != fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", fn.NumParameters, numArgs)
+	}
+
+	frame := NewFrame(cl, vm.sp-numArgs)
+	if vm.framesIndex >= MAXFRAMES || frame.basePointer+fn.NumLocals >= STACKSIZE {
+		return fmt.Errorf("stack overflow")
+	}
+	vm.pushFrame(frame)
+
+	// Arguments already sit on the stack, reserve the remaining locals
+	vm.sp = frame.basePointer + fn.NumLocals
+
+	return nil
+}
+
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		arrayObject := left.(*object.Array)
+		i := index.(*object.Integer).Value
+		max := int64(len(arrayObject.Elements) - 1)
+
+		if i < 0 || i > max {
+			return vm.push(Null)
+		}
+
+		// Storing an array in itself would make it cyclic, store a copy instead
+		if value == left {
+			elements := make([]object.Object, len(arrayObject.Elements))
+			copy(elements, arrayObject.Elements)
+			arrayObject.Elements[i] = &object.Array{Elements: elements}
+		} else {
+			arrayObject.Elements[i] = value
+		}
+		return vm.push(value)
+
+	case left.Type() == object.HASH_OBJ:
+		hashObject := left.(*object.Hash)
+
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+
+		if value == left {
+			pairs := make(map[object.HashKey]object.HashPair)
+			for k, v := range hashObject.Pairs {
+				pairs[k] = v
+			}
+			hashObject.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: &object.Hash{Pairs: pairs}}
+		} else {
+			hashObject.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+		}
+		return vm.push(value)
+
+	default:
+		return fmt.Errorf("index operator not supported: %s", left.Type())
+	}
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.Fn(args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	// Errors from builtins abort the program, the same way they do in the
+	// evaluator
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+
+	if result != nil {
+		return vm.push(result)
+	}
+	return vm.push(Null)
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
+
+// popIterator pops the iterator of a for loop
+func (vm *VM) popIterator() (*object.Iterator, error) {
+	value := vm.pop()
+	iterator, ok := value.(*object.Iterator)
+	if !ok {
+		return nil, fmt.Errorf("not an iterator: %s", value.Type())
+	}
+	return iterator, nil
+}
+
+// pushIteratorValues pushes the current element for a single loop variable,
+// or the key followed by the value for two.
+func (vm *VM) pushIteratorValues(iterator *object.Iterator, numValues int) error {
+	if numValues == 1 {
+		return vm.push(iterator.Element())
+	}
+
+	err := vm.push(iterator.Key())
+	if err != nil {
+		return err
+	}
+	return vm.push(iterator.Value())
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+
+	for i := startIndex; i < endIndex; i++ {
+		elements[i-startIndex] = vm.stack[i]
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	hashedPairs := make(map[object.HashKey]object.HashPair)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+
+		hashedPairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: hashedPairs}, nil
+}
+
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeStringIndex(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return vm.executeHashIndex(left, index)
+	default:
+		return fmt.Errorf("index operator not supported: %s", left.Type())
+	}
+}
+
+func (vm *VM) executeArrayIndex(array, index object.Object) error {
+	arrayObject := array.(*object.Array)
+	i := index.(*object.Integer).Value
+	max := int64(len(arrayObject.Elements) - 1)
+
+	if i < 0 || i > max {
+		return vm.push(Null)
+	}
+
+	return vm.push(arrayObject.Elements[i])
+}
+
+// Strings are indexed by character, not by byte
+func (vm *VM) executeStringIndex(str, index object.Object) error {
+	chars := []rune(str.(*object.String).Value)
+	i := index.(*object.Integer).Value
+	max := int64(len(chars) - 1)
+
+	if i < 0 || i > max {
+		return vm.push(Null)
+	}
+
+	return vm.push(&object.String{Value: string(chars[i])})
+}
+
+func (vm *VM) executeHashIndex(hash, index object.Object) error {
+	hashObject := hash.(*object.Hash)
+
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return fmt.Errorf("unusable as hash key: %s", index.Type())
+	}
+
+	pair, ok := hashObject.Pairs[key.HashKey()]
+	if !ok {
+		return vm.push(Null)
+	}
+
+	return vm.push(pair.Value)
+}
+
 func (vm *VM) push(o object.Object) error {
 	if vm.sp >= STACKSIZE {
 		return fmt.Errorf("stack overflow")
@@ -172,11 +774,46 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	} else if (left.Type() == object.FLOAT_OBJ || left.Type() == object.INTEGER_OBJ) &&
 		(right.Type() == object.FLOAT_OBJ || right.Type() == object.INTEGER_OBJ) {
 		return vm.executeBinaryFloatOperation(op, left, right)
+	} else if leftType == object.STRING_OBJ && rightType == object.STRING_OBJ {
+		return vm.executeBinaryStringOperation(op, left, right)
 	}
 
 	return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
 }
 
+// operators maps the opcodes of binary operations to the operators they
+// are compiled from, for error messages
+var operators = map[code.Opcode]string{
+	code.OpAdd:              "+",
+	code.OpSub:              "-",
+	code.OpMul:              "*",
+	code.OpDiv:              "/",
+	code.OpMod:              "%",
+	code.OpEqual:            "==",
+	code.OpNotEqual:         "!=",
+	code.OpGreaterThan:      ">",
+	code.OpGreaterThanEqual: ">=",
+	code.OpLessThan:         "<",
+	code.OpLessThanEqual:    "<=",
+}
+
+// unknownOperator reports op applied to left and right like the evaluator
+// does
+func unknownOperator(op code.Opcode, left, right object.Object) error {
+	return fmt.Errorf("unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
+}
+
+func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
+	if op != code.OpAdd {
+		return unknownOperator(op, left, right)
+	}
+
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	return vm.push(&object.String{Value: leftValue + rightValue})
+}
+
 func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
 	leftValue := left.(*object.Integer).Value
 	rightValue := right.(*object.Integer).Value
@@ -195,7 +832,7 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.O
 	case code.OpMod:
 		result = leftValue % rightValue
 	default:
-		return fmt.Errorf("unknown integer operation: %d", op)
+		return unknownOperator(op, left, right)
 	}
 
 	return vm.push(&object.Integer{Value: result})
@@ -233,7 +870,7 @@ func (vm *VM) executeBinaryFloatOperation(op code.Opcode, left, right object.Obj
 	case code.OpMod:
 		result = math.Mod(leftValue, rightValue)
 	default:
-		return fmt.Errorf("unknown float operation: %d", op)
+		return unknownOperator(op, left, right)
 	}
 	return vm.push(&object.Float{Value: result})
 }
@@ -251,13 +888,17 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 		return vm.executeFloatComparison(op, left, right)
 	}
 
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		return vm.executeStringComparison(op, left, right)
+	}
+
 	switch op {
 	case code.OpEqual:
 		return vm.push(nativeBooleanObject(right == left))
 	case code.OpNotEqual:
 		return vm.push(nativeBooleanObject(right != left))
 	default:
-		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+		return unknownOperator(op, left, right)
 	}
 }
 
@@ -272,8 +913,14 @@ func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object
 		return vm.push(nativeBooleanObject(rightValue != leftValue))
 	case code.OpGreaterThan:
 		return vm.push(nativeBooleanObject(leftValue > rightValue))
+	case code.OpGreaterThanEqual:
+		return vm.push(nativeBooleanObject(leftValue >= rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBooleanObject(leftValue < rightValue))
+	case code.OpLessThanEqual:
+		return vm.push(nativeBooleanObject(leftValue <= rightValue))
 	default:
-		return fmt.Errorf("unknown operator: %d", op)
+		return unknownOperator(op, left, right)
 	}
 }
 
@@ -302,8 +949,28 @@ func (vm *VM) executeFloatComparison(op code.Opcode, left, right object.Object)
 		return vm.push(nativeBooleanObject(rightValue != leftValue))
 	case code.OpGreaterThan:
 		return vm.push(nativeBooleanObject(leftValue > rightValue))
+	case code.OpGreaterThanEqual:
+		return vm.push(nativeBooleanObject(leftValue >= rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBooleanObject(leftValue < rightValue))
+	case code.OpLessThanEqual:
+		return vm.push(nativeBooleanObject(leftValue <= rightValue))
+	default:
+		return unknownOperator(op, left, right)
+	}
+}
+
+func (vm *VM) executeStringComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBooleanObject(rightValue == leftValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBooleanObject(rightValue != leftValue))
 	default:
-		return fmt.Errorf("unknown operator: %d", op)
+		return unknownOperator(op, left, right)
 	}
 }
 
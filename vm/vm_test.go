@@ -3,6 +3,7 @@ package vm
 import (
 	"fmt"
 	"monkey/ast"
+	"monkey/code"
 	"monkey/compiler"
 	"monkey/lexer"
 	"monkey/object"
@@ -40,6 +41,35 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 	}
 }
 
+type vmErrorTestCase struct {
+	input    string
+	expected string
+}
+
+func runVmErrorTests(t *testing.T, tests []vmErrorTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error for %q but resulted in none.", tt.input)
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
 func testExpectedObject(t *testing.T, expected interface{}, actual object.Object) {
 	t.Helper()
 
@@ -62,6 +92,55 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 			t.Errorf("testBooleanObject failed: %s", err)
 		}
 
+	case string:
+		err := testStringObject(expected, actual)
+		if err != nil {
+			t.Errorf("testStringObject failed: %s", err)
+		}
+
+	case []int:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object not Array: %T (%+v)", actual, actual)
+			return
+		}
+
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong num of elements. want=%d, got=%d", len(expected), len(array.Elements))
+			return
+		}
+
+		for i, expectedElem := range expected {
+			err := testIntegerObject(int64(expectedElem), array.Elements[i])
+			if err != nil {
+				t.Errorf("testIntegerObject failed: %s", err)
+			}
+		}
+
+	case map[object.HashKey]int64:
+		hash, ok := actual.(*object.Hash)
+		if !ok {
+			t.Errorf("object is not Hash. got=%T (%+v)", actual, actual)
+			return
+		}
+
+		if len(hash.Pairs) != len(expected) {
+			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d", len(expected), len(hash.Pairs))
+			return
+		}
+
+		for expectedKey, expectedValue := range expected {
+			pair, ok := hash.Pairs[expectedKey]
+			if !ok {
+				t.Errorf("no pair for given key in Pairs")
+			}
+
+			err := testIntegerObject(expectedValue, pair.Value)
+			if err != nil {
+				t.Errorf("testIntegerObject failed: %s", err)
+			}
+		}
+
 	case *object.Null:
 		if actual != Null {
 			t.Errorf("object is not Null: %T(%+v)", actual, actual)
@@ -114,6 +193,19 @@ func testBooleanObject(expected bool, actual object.Object) error {
 	return nil
 }
 
+func testStringObject(expected string, actual object.Object) error {
+	result, ok := actual.(*object.String)
+	if !ok {
+		return fmt.Errorf("object is not String. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%q want=%q", result.Value, expected)
+	}
+
+	return nil
+}
+
 func TestIntegerArithmetic(t *testing.T) {
 	tests := []vmTestCase{
 		{"1", 1},
@@ -190,6 +282,25 @@ func TestBooleanExpressions(t *testing.T) {
 		{"!!true", true},
 		{"!!false", false},
 		{"!!5", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"2.5 >= 2", true},
+		{"2 <= 1.5", false},
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"true || false", true},
+		{"1 && 0", true},
+		{"if (false) { 1 } || 2 > 1", true},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 >= 3", false},
+		{"true || false && false", true},
+		{"let x = 3; let n = 5; x >= 0 && x < n", true},
 	}
 
 	runVmTests(t, tests)
@@ -207,6 +318,12 @@ func TestConditionals(t *testing.T) {
 		{"if (1 > 2) { 10 }", Null},
 		{"if (false) { 10 }", Null},
 		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		{"if (1 > 2) { 10 } else if (2 > 1) { 20 } else { 30 }", 20},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 } else { 30 }", 30},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 }", Null},
+		{"if (1 < 2) { 10 } else if (2 > 1) { 20 } else { 30 }", 10},
+		{"let x = 4; if (x == 1) { 10 } else if (x == 2) { 20 } else if (x == 3) { 30 } else { 40 }", 40},
+		{"let f = fn(x) { if (x < 0) { return -1 } else if (x == 0) { return 0 } else { return 1 } }; f(0)", 0},
 	}
 
 	runVmTests(t, tests)
@@ -221,3 +338,646 @@ func TestGlobalLetStatements(t *testing.T) {
 
 	runVmTests(t, tests)
 }
+
+func TestStringExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`"waffle"`, "waffle"},
+		{`"waf" + "fle"`, "waffle"},
+		{`"waf" + "fle" + "s"`, "waffles"},
+		{`"waffle" == "waffle"`, true},
+		{`"waffle" == "pancake"`, false},
+		{`"waffle" != "pancake"`, true},
+		{`"waffle" != "waffle"`, false},
+		{`let a = "waf"; let b = "fle"; a + b == "waffle"`, true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestArrayLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", []int{}},
+		{"[1, 2, 3]", []int{1, 2, 3}},
+		{"[1 + 2, 3 * 4, 5 + 6]", []int{3, 12, 11}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestHashLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"{}", map[object.HashKey]int64{}},
+		{
+			"{1: 2, 2: 3}",
+			map[object.HashKey]int64{
+				(&object.Integer{Value: 1}).HashKey(): 2,
+				(&object.Integer{Value: 2}).HashKey(): 3,
+			},
+		},
+		{
+			"{1 + 1: 2 * 2, 3 + 3: 4 * 4}",
+			map[object.HashKey]int64{
+				(&object.Integer{Value: 2}).HashKey(): 4,
+				(&object.Integer{Value: 6}).HashKey(): 16,
+			},
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][0 + 2]", 3},
+		{"[[1, 1, 1]][0][0]", 1},
+		{"[][0]", Null},
+		{"[1, 2, 3][99]", Null},
+		{"[1][-1]", Null},
+		{"{1: 1, 2: 2}[1]", 1},
+		{"{1: 1, 2: 2}[2]", 2},
+		{"{1: 1}[0]", Null},
+		{"{}[0]", Null},
+		{`{"one": 1, true: 2}["one"]`, 1},
+		{`{"one": 1, true: 2}[true]`, 2},
+		{`"abc"[1]`, "b"},
+		{`"héllo"[1]`, "é"},
+		{`"世界"[1]`, "界"},
+		{`"abc"[3]`, Null},
+		{`"abc"[-1]`, Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexErrors(t *testing.T) {
+	tests := []vmErrorTestCase{
+		{"{[1]: 2}", "unusable as hash key: ARRAY"},
+		{"{1: 2}[[1]]", "unusable as hash key: ARRAY"},
+		{"{1: 2}[fn(x) { x }]", "unusable as hash key: CLOSURE"},
+		{"1[0]", "index operator not supported: INTEGER"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestOperatorErrors(t *testing.T) {
+	tests := []vmErrorTestCase{
+		{`"Hello" - "World"`, "unknown operator: STRING - STRING"},
+		{`"a" * "b"`, "unknown operator: STRING * STRING"},
+		{`"a" > "b"`, "unknown operator: STRING > STRING"},
+		{`"a" >= "b"`, "unknown operator: STRING >= STRING"},
+		{`"a" <= "b"`, "unknown operator: STRING <= STRING"},
+		{"true > false", "unknown operator: BOOLEAN > BOOLEAN"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestCallingFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let fivePlusTen = fn() { 5 + 10; }; fivePlusTen();", 15},
+		{"let one = fn() { 1; }; let two = fn() { 2; }; one() + two()", 3},
+		{"let a = fn() { 1 }; let b = fn() { a() + 1 }; let c = fn() { b() + 1 }; c();", 3},
+		{"let earlyExit = fn() { return 99; 100; }; earlyExit();", 99},
+		{"let earlyExit = fn() { return 99; return 100; }; earlyExit();", 99},
+		{"let noReturn = fn() { }; noReturn();", Null},
+		{"let noReturn = fn() { }; let noReturnTwo = fn() { noReturn(); }; noReturn(); noReturnTwo();", Null},
+		{"fn() { 24 }()", 24},
+		{"if (true) { return 10; 20 }", 10},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFirstClassFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let returnsOne = fn() { 1; }; let returnsOneReturner = fn() { returnsOne; }; returnsOneReturner()();", 1},
+		{"let function = fn() { return fn(x, y) { return x > y; }; }; let checkGreater = function(); checkGreater(1, 2);", false},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctionsWithBindings(t *testing.T) {
+	tests := []vmTestCase{
+		{"let one = fn() { let one = 1; one }; one();", 1},
+		{"let oneAndTwo = fn() { let one = 1; let two = 2; one + two; }; oneAndTwo();", 3},
+		{`
+    let oneAndTwo = fn() { let one = 1; let two = 2; one + two; };
+    let threeAndFour = fn() { let three = 3; let four = 4; three + four; };
+    oneAndTwo() + threeAndFour();
+    `, 10},
+		{`
+    let globalSeed = 50;
+    let minusOne = fn() { let num = 1; globalSeed - num; };
+    let minusTwo = fn() { let num = 2; globalSeed - num; };
+    minusOne() + minusTwo();
+    `, 97},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctionsWithArgumentsAndBindings(t *testing.T) {
+	tests := []vmTestCase{
+		{"let identity = fn(a) { a; }; identity(4);", 4},
+		{"let sum = fn(a, b) { a + b; }; sum(1, 2);", 3},
+		{"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2);", 3},
+		{"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2) + sum(3, 4);", 10},
+		{`
+    let sum = fn(a, b) { let c = a + b; c; };
+    let outer = fn() { sum(1, 2) + sum(3, 4); };
+    outer();
+    `, 10},
+		{`
+    let globalNum = 10;
+    let sum = fn(a, b) { let c = a + b; c + globalNum; };
+    let outer = fn() { sum(1, 2) + sum(3, 4) + globalNum; };
+    outer() + globalNum;
+    `, 50},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctionsWithWrongArguments(t *testing.T) {
+	tests := []vmErrorTestCase{
+		{`fn() { 1; }(1);`, "wrong number of arguments: want=0, got=1"},
+		{`fn(a) { a; }();`, "wrong number of arguments: want=1, got=0"},
+		{`fn(a, b) { a + b; }(1);`, "wrong number of arguments: want=2, got=1"},
+		{`1();`, "calling non-function"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+    let newClosure = fn(a) { fn() { a; }; };
+    let closure = newClosure(99);
+    closure();
+    `, 99},
+		{`
+    let newAdder = fn(a, b) { fn(c) { a + b + c }; };
+    let adder = newAdder(1, 2);
+    adder(8);
+    `, 11},
+		{`
+    let newAdder = fn(a, b) { let c = a + b; fn(d) { c + d }; };
+    let adder = newAdder(1, 2);
+    adder(8);
+    `, 11},
+		{`
+    let newAdderOuter = fn(a, b) {
+      let c = a + b;
+      fn(d) {
+        let e = d + c;
+        fn(f) { e + f; };
+      };
+    };
+    let newAdderInner = newAdderOuter(1, 2)
+    let adder = newAdderInner(3);
+    adder(8);
+    `, 14},
+		{`
+    let a = 1;
+    let newAdderOuter = fn(b) {
+      fn(c) {
+        fn(d) { a + b + c + d };
+      };
+    };
+    let newAdderInner = newAdderOuter(2)
+    let adder = newAdderInner(3);
+    adder(8);
+    `, 14},
+		{`
+    let newClosure = fn(a, b) {
+      let one = fn() { a; };
+      let two = fn() { b; };
+      fn() { one() + two(); };
+    };
+    let closure = newClosure(9, 90);
+    closure();
+    `, 99},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+    let countDown = fn(x) {
+      if (x == 0) {
+        return 0;
+      } else {
+        countDown(x - 1);
+      }
+    };
+    countDown(1);
+    `, 0},
+		{`
+    let wrapper = fn() {
+      let countDown = fn(x) {
+        if (x == 0) {
+          return 0;
+        } else {
+          countDown(x - 1);
+        }
+      };
+      countDown(1);
+    };
+    wrapper();
+    `, 0},
+		{`
+    let fibonacci = fn(x) {
+      if (x == 0) {
+        return 0;
+      } else {
+        if (x == 1) {
+          return 1;
+        } else {
+          fibonacci(x - 1) + fibonacci(x - 2);
+        }
+      }
+    };
+    fibonacci(15);
+    `, 610},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("hello world")`, 11},
+		{`len("héllo, 世界")`, 9},
+		{`len([1, 2, 3])`, 3},
+		{`len([])`, 0},
+		{`puts("hello", "world!")`, Null},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, Null},
+		{`last([1, 2, 3])`, 3},
+		{`last([])`, Null},
+		{`rest([1, 2, 3])`, []int{2, 3}},
+		{`rest([])`, Null},
+		{`push([], 1)`, []int{1}},
+		{`let a = [1, 2]; let f = fn(arr) { len(push(arr, 3)) }; f(a)`, 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctionErrors(t *testing.T) {
+	tests := []vmErrorTestCase{
+		{`len(1)`, "argument to `len` not supported, got INTEGER"},
+		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`first(1)`, "argument to `first` must be an ARRAY, got INTEGER"},
+		{`last(1)`, "argument to `last` must be an ARRAY, got INTEGER"},
+		{`rest(1)`, "argument to `rest` must be an ARRAY, got INTEGER"},
+		{`push(1, 1)`, "argument to `push` must be an ARRAY, got INTEGER"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestBreakContinue(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; loop (true) { i = i + 1; if (i == 5) { break; } }; i", 5},
+		{"let i = 0; let sum = 0; loop (i < 10) { i = i + 1; if (i % 2 == 0) { continue; } sum = sum + i; }; sum", 25},
+		{"let i = 0; loop (i < 3) { i = i + 1; if (i == 3) { break; } i * 10 }", Null},
+		{"let i = 0; loop (i < 3) { i = i + 1; if (i == 3) { continue; } i * 10 }", Null},
+		{"let i = 0; let n = 0; loop (i < 3) { i = i + 1; let j = 0; loop (true) { j = j + 1; if (j == 2) { break; } n = n + 1; } }; n", 3},
+		{"let f = fn() { let i = 0; loop (true) { i = i + 1; if (i == 4) { return i * 2; } } }; f()", 8},
+		{"let f = fn() { let i = 0; loop (i < 5) { i = i + 1; if (i < 5) { continue; } break; }; i }; f()", 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBlockScoping(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; if (true) { let x = 2; }; x", 1},
+		{"let x = 1; if (true) { let x = 2; x }", 2},
+		{"let x = 1; if (true) { x = 2; }; x", 2},
+		{"let x = 1; if (false) { 0 } else { let x = 3; }; x", 1},
+		{"let a = 1; if (true) { let a = 2; if (true) { let a = 3; }; a }", 2},
+		{"let a = 1; if (true) { let b = a + 1; if (true) { a = b * 10 } }; a", 20},
+		{"let i = 0; let t = 0; loop (i < 3) { let x = i * 2; t = t + x; i = i + 1 }; t", 6},
+		{"let x = 10; for (x in [1, 2]) { x }; x", 10},
+		{"const c = 1; if (true) { let c = 2; c }", 2},
+		{"let f = fn(x) { if (true) { let x = x * 2; }; x }; f(5)", 5},
+		{"let x = 1; let f = fn() { if (true) { let x = 10; x } }; f() + x", 11},
+		{"let f = fn() { let fs = []; for (i in [1, 2, 3]) { fs = push(fs, fn() { i }) }; fs[0]() + fs[2]() }; f()", 4},
+		{"let f = fn() { let a = 1; if (true) { let b = 2; fn() { a + b } } }; f()()", 3},
+		{"let fs = []; for (i in [1, 2, 3]) { fs = push(fs, fn() { i }) }; fs[0]() + fs[2]()", 4},
+		{"let fs = []; let i = 0; loop (i < 3) { let k = i; fs = push(fs, fn() { k }); i = i + 1 }; fs[0]() + fs[2]()", 2},
+		{"let g = if (true) { let n = 0; fn() { n = n + 1; n } }; g(); g()", 2},
+		{"let f = if (true) { let a = 1; fn() { a } }; if (true) { let b = 2; f() + b }", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestConstStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"const a = 5; a;", 5},
+		{"const a = 5; const b = a * 2; b;", 10},
+		{"const a = 5; let f = fn() { let a = 1; a = 2; a }; f();", 2},
+		{"const a = 5; let f = fn(a) { a = a + 1; a }; f(1);", 2},
+		{"const a = [1]; a[0] = 3; a[0];", 3},
+		{"let f = fn() { const a = 1; fn() { a } }; f()();", 1},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestForExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let t = 0; for (x in [1, 2, 3]) { t = t + x }; t", 6},
+		{"for (x in [1, 2, 3]) { x * 10 }", 30},
+		{"for (x in []) { x }", Null},
+		{"let t = 0; for (i, x in [5, 6, 7]) { t = t + i * x }; t", 20},
+		{"let t = 0; for (k in {3: 1, 1: 2, 2: 3}) { t = t * 10 + k }; t", 123},
+		{"let t = 0; for (k, v in {3: 1, 1: 2, 2: 3}) { t = t * 10 + v }; t", 231},
+		{"let s = \"\"; for (c in \"abc\") { s = c + s }; s", "cba"},
+		{"let t = 0; for (i, c in \"abc\") { t = t + i }; t", 3},
+		{"let n = 0; for (c in \"añ世\") { n = n + 1 }; n == len(\"añ世\")", true},
+		{"let s = \"añ世b\"; let same = 0; for (i, c in s) { if (s[i] == c) { same = same + 1 } }; same", 4},
+		{"let t = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } if (x == 4) { break; } t = t + x }; t", 4},
+		{"let t = 0; for (x in [1, 2]) { for (y in [10, 20]) { t = t + x * y } }; t", 90},
+		{"let a = [1, 2]; let n = 0; for (x in a) { a[0] = 5; n = n + 1 }; n", 2},
+		{"let f = fn(xs) { for (x in xs) { if (x > 1) { return x; } } }; f([1, 2, 3])", 2},
+		{"let f = fn() { let t = 0; for (x in [1, 2]) { for (y in [10, 20]) { t = t + x * y } }; t }; f()", 90},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"loop (false) { 10 }", Null},
+		{"let i = 0; loop (i < 3) { i = i + 1; let x = i; }", Null},
+		{"let i = 0; loop (i < 3) { i = i + 1; i * 10 }", 30},
+		{"let i = 0; let num = 0; loop (i < 2) { num = num + 1; i = i + 1; }; num;", 2},
+		{`
+    let iterator = fn(num) {
+      let i = 0;
+      loop (i < 10) { i = i + 1; num = num + 1; };
+      num;
+    };
+    iterator(0);
+    `, 10},
+		{`
+    let sum = fn(arr) {
+      let i = 0;
+      let total = 0;
+      loop (i < len(arr)) {
+        total = total + arr[i];
+        i = i + 1;
+      };
+      total
+    };
+    sum([1, 2, 3, 4]);
+    `, 10},
+		{`
+    let i = 0;
+    let total = 0;
+    loop (i < 3) {
+      let j = 0;
+      loop (j < 3) { total = total + 1; j = j + 1; };
+      i = i + 1;
+    };
+    total;
+    `, 9},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 1; a = 2; a", 2},
+		{"let a = 1; a = 2", 2},
+		{"let a = 1; let b = 1; a = b = 5; a + b", 10},
+		{"let f = fn(a) { a = a + 1; a }; f(1)", 2},
+		{"let f = fn() { let a = 1; a = 5; a }; f()", 5},
+		{"let a = 1; let f = fn() { a = 10; }; f(); a", 10},
+		{`
+    let counter = fn() {
+      let count = 0;
+      fn() { count = count + 1; count };
+    };
+    let next = counter();
+    next();
+    next();
+    next();
+    `, 3},
+		{"let f = fn() { let x = 1; let g = fn() { x = 2 }; g(); x }; f()", 2},
+		{"let f = fn(x) { let g = fn() { x = x * 2 }; g(); g(); x }; f(3)", 12},
+		{`
+    let counter = fn() {
+      let count = 0;
+      let inc = fn() { count = count + 1 };
+      let get = fn() { count };
+      [inc, get]
+    };
+    let c = counter();
+    c[0]();
+    c[0]();
+    c[1]();
+    `, 2},
+		{`
+    let f = fn() {
+      let x = 1;
+      let g = fn() { fn() { x = x + 1 } };
+      let h = g();
+      h();
+      h();
+      x
+    };
+    f()
+    `, 3},
+		{"let f = fn() { let x = 1; let g = fn() { x }; x = 5; g() }; f()", 5},
+		{"let f = fn() { let x = 1; let g = fn() { x }; let x = 5; g() }; f()", 5},
+		{"let arr = [1, 2, 3]; arr[0] = 10; arr", []int{10, 2, 3}},
+		{"let arr = [1, 2, 3]; arr[1] = 10", 10},
+		{"let arr = [1, 2, 3]; arr[5] = 10", Null},
+		{"let arr = [1, 2, 3]; arr[5] = 10; arr", []int{1, 2, 3}},
+		{"let arr = [[1], [2]]; arr[1][0] = 3; arr[1]", []int{3}},
+		{`let h = {"a": 1}; h["a"] = 2; h["a"]`, 2},
+		{`let h = {}; let key = "age"; h[key] = 32; h[key]`, 32},
+		{`let h = {}; h["a"] = 2`, 2},
+		{"let n = 0; let arr = [1]; arr[5] = fn() { n = n + 1 }(); n", 1},
+		{"let f = fn() { f = 2; }; f(); f", 2},
+		{"let g = fn() { let f = fn() { f = 3 }; f(); f }; g()", 3},
+		{"if (true) { let f = fn() { f = 4 }; f(); f }", 4},
+		{`let h = {}; h[1] = 2; h`, map[object.HashKey]int64{(&object.Integer{Value: 1}).HashKey(): 2}},
+		{`let i = 0; loop (i < 5) { i = i + 1 }; i`, 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrorTraceback(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedTraceback string
+	}{
+		{"let f = fn() {\n  -true\n};\nf()", "ERROR: 2:3: unsupported type for negation: BOOLEAN\n  in f called at 4:2"},
+		{
+			"let add = fn(a, b) { a + b };\nlet g = fn() { add(1, true) };\ng();",
+			"ERROR: 1:24: unsupported types for binary operation: INTEGER BOOLEAN\n  in add called at 2:19\n  in g called at 3:2",
+		},
+		{
+			"let f = fn(n) { if (n == 0) { len(1) } else { f(n - 1) } };\nf(2)",
+			"ERROR: 1:34: argument to `len` not supported, got INTEGER\n  in f called at 1:48 (2 times)\n  in f called at 2:2",
+		},
+		{"let f = fn(a) { a };\nf()", "ERROR: 2:2: wrong number of arguments: want=1, got=0"},
+		{"fn() { -true }()", "ERROR: 1:8: unsupported type for negation: BOOLEAN\n  in <anonymous> called at 1:15"},
+		{"let f = fn() {\n  throw \"x\"\n};\ntry { f() } finally { 1 }", "ERROR: 2:3: x\n  in f called at 4:8"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+
+		runtimeErr, ok := err.(*object.Error)
+		if !ok {
+			t.Fatalf("expected *object.Error for %q, got=%T (%v)", tt.input, err, err)
+		}
+
+		if runtimeErr.Traceback() != tt.expectedTraceback {
+			t.Errorf("wrong traceback. want=%q, got=%q", tt.expectedTraceback, runtimeErr.Traceback())
+		}
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []vmTestCase{
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { throw "boom" } catch (e) { e["message"] }`, "boom"},
+		{`try { throw "boom" } catch (e) { e["type"] }`, "Error"},
+		{`try { throw {"message": "bad", "type": "ValueError"} } catch (e) { e["type"] + ": " + e["message"] }`, "ValueError: bad"},
+		{`try { throw 42 } catch (e) { e["message"] }`, "42"},
+		{`try { 1 + true } catch (e) { e["type"] }`, "RuntimeError"},
+		{`try { len(1) } catch (e) { e["message"] }`, "argument to `len` not supported, got INTEGER"},
+		{`try { throw "x" } catch { 2 }`, 2},
+		{`let x = 0; try { x = 1 } finally { x = x + 10 }; x`, 11},
+		{`let x = 0; try { throw "x" } catch (e) { x = 1 } finally { x = x + 10 }; x`, 11},
+		{`try { 1 } finally { 2 }`, 1},
+		{`1 + try { throw "x" } catch (e) { 2 }`, 3},
+		{`let f = fn(n) { if (n == 0) { throw "bottom" } f(n - 1) }; try { f(5) } catch (e) { e["message"] }`, "bottom"},
+		{`let f = fn() { throw "inner" }; let g = fn() { try { f() } catch (e) { "caught " + e["message"] } }; g()`, "caught inner"},
+		{`try { try { throw "a" } catch (e) { throw e["message"] + "b" } } catch (e) { e["message"] }`, "ab"},
+		{`let n = 0; try { try { throw "a" } finally { n = n + 1 } } catch (e) { n = n + 10 }; n`, 11},
+		{`let n = 0; try { try { throw "a" } catch (e) { throw "b" } finally { n = n + 1 } } catch (e) { n = n + 10 }; n`, 11},
+		{`let n = 0; let f = fn() { try { return 1 } finally { n = 5 } }; f() + n`, 6},
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, 2},
+		{`let n = 0; let i = 0; loop (i < 4) { i = i + 1; try { if (i == 2) { continue } if (i == 3) { break } } finally { n = n + 1 } }; n`, 3},
+		{`let i = 0; loop (i < 5000) { i = i + 1; try { throw "x" } finally { continue } }; i`, 5000},
+		{`let i = 0; loop (i < 5000) { i = i + 1; try { i } finally { continue } }; i`, 5000},
+		{`let i = 0; loop (true) { i = i + 1; try { throw "x" } catch (e) { i } finally { if (i == 5000) { break } } }; i`, 5000},
+		{`let f = fn() { let i = 0; loop (i < 5000) { i = i + 1; try { return 1 } finally { continue } }; i }; f()`, 5000},
+		{`let t = 0; for (x in [1, 2, 3]) { try { throw "x" } finally { t = t + x; if (x == 2) { break } else { continue } } }; t`, 3},
+		{`let t = 0; for (x in [1, 2, 3]) { try { if (x == 2) { throw "skip" } t = t + x } catch (e) { t = t + 100 } }; t`, 104},
+		{`let f = fn() { let i = 0; loop (true) { i = i + 1; try { if (i == 3) { return i } } catch (e) { 0 } } }; try { f() } catch (e) { -1 }`, 3},
+		{`let f = fn() { try { return 1 } catch (e) { 0 } }; f(); try { throw "after" } catch (e) { e["message"] }`, "after"},
+		{`let e = 1; try { throw "x" } catch (e) { e }; e`, 1},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestUncaughtErrors(t *testing.T) {
+	tests := []vmErrorTestCase{
+		{`throw "boom"`, "boom"},
+		{`throw {"message": "bad"}`, "bad"},
+		{`let n = 0; try { throw "kept" } finally { n = 1 }`, "kept"},
+		{`try { throw "a" } catch (e) { throw e }`, "a"},
+		{`try { 1 + true } catch (e) { 1 }; -true`, "unsupported type for negation: BOOLEAN"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []vmErrorTestCase{
+		{`let h = {}; h[[1]] = 2`, "unusable as hash key: ARRAY"},
+		{`let a = 1; a[0] = 2`, "index operator not supported: INTEGER"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestLogicalShortCircuit(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 0; false && (a = 1); a", 0},
+		{"let a = 0; true && (a = 1); a", 1},
+		{"let a = 0; true || (a = 1); a", 0},
+		{"let a = 0; false || (a = 1); a", 1},
+		{"let arr = []; len(arr) > 0 && arr[0] > 1", false},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestComparisonOrder(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 0; (a = 1) < (a = 2); a", 2},
+		{"let a = 0; (a = 1) <= (a = 2); a", 2},
+		{"let a = 0; (a = 1) > (a = 2); a", 2},
+		{"let a = 0; (a = 1) >= (a = 2); a", 2},
+		{"1 < 2.5", true},
+		{"2.5 <= 2", false},
+	}
+
+	runVmTests(t, tests)
+}
+
+// Instructions the compiler doesn't emit but a damaged compiled file can
+// contain fail with an error instead of crashing the VM
+func TestInvalidInstructions(t *testing.T) {
+	tests := []struct {
+		instructions [][]byte
+		expected     string
+	}{
+		{[][]byte{code.Make(code.OpTrue), code.Make(code.OpIterNext)}, "not an iterator: BOOLEAN"},
+		{[][]byte{code.Make(code.OpTrue), code.Make(code.OpIterValues, 1)}, "not an iterator: BOOLEAN"},
+		{[][]byte{code.Make(code.OpTrue), code.Make(code.OpCatch)}, "cannot catch BOOLEAN"},
+		{[][]byte{code.Make(code.OpEndTry)}, "no try to end"},
+		{[][]byte{code.Make(code.OpReturn), code.Make(code.OpEndTry)}, ""},
+	}
+
+	for _, tt := range tests {
+		instructions := code.Instructions{}
+		for _, ins := range tt.instructions {
+			instructions = append(instructions, ins...)
+		}
+
+		vm := New(&compiler.Bytecode{Instructions: instructions})
+		err := vm.Run()
+
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("unexpected VM error for %q: %s", instructions, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong VM error for %q. want=%q, got=%v", instructions, tt.expected, err)
+		}
+	}
+}
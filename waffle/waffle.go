@@ -0,0 +1,218 @@
+// Package waffle runs Waffle code from Go programs.
+//
+//	interp := waffle.New(waffle.EngineVM)
+//	interp.SetGlobal("limit", &object.Integer{Value: 10})
+//	result, err := interp.Eval(`limit * 2`)
+//
+// Variables defined by one call to Eval stay visible to the following ones.
+// An Interpreter is not safe for concurrent use.
+package waffle
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"strings"
+)
+
+type Engine int
+
+const (
+	// EngineVM compiles the code to bytecode and runs it on the virtual
+	// machine
+	EngineVM Engine = iota
+	// EngineEval walks the syntax tree
+	EngineEval
+)
+
+func (e Engine) String() string {
+	switch e {
+	case EngineVM:
+		return "vm"
+	case EngineEval:
+		return "eval"
+	}
+	return fmt.Sprintf("Engine(%d)", int(e))
+}
+
+// ParseError is returned by Eval when the code doesn't parse.
+type ParseError struct {
+	Messages []string
+}
+
+func (e *ParseError) Error() string {
+	return "parser errors:\n\t" + strings.Join(e.Messages, "\n\t")
+}
+
+type Interpreter struct {
+	engine Engine
+
+	// EngineVM
+	constants   []object.Object
+	globals     []object.Object
+	symbolTable *compiler.SymbolTable
+
+	// EngineEval
+	env *object.Environment
+}
+
+func New(engine Engine) *Interpreter {
+	interp := &Interpreter{engine: engine}
+
+	if engine == EngineEval {
+		interp.env = object.NewEnvironment()
+		return interp
+	}
+
+	interp.constants = []object.Object{}
+	interp.globals = make([]object.Object, vm.GLOBALSSIZE)
+	interp.symbolTable = compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		interp.symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	return interp
+}
+
+func (interp *Interpreter) Engine() Engine {
+	return interp.engine
+}
+
+// Eval runs src and returns the value of its last statement, null if that
+// isn't an expression. It fails with a *ParseError if src doesn't parse, and
+// with the *object.Error raised if it fails to run. A panic of the engine is
+// returned as an *object.Error too.
+func (interp *Interpreter) Eval(src string) (result object.Object, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if interp.engine == EngineVM {
+				interp.nullUnsetGlobals()
+			}
+			result, err = nil, &object.Error{Message: fmt.Sprint(r)}
+		}
+	}()
+
+	l := lexer.New(src)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Messages: p.Errors()}
+	}
+
+	if interp.engine == EngineEval {
+		return interp.evaluate(program)
+	}
+	return interp.run(program)
+}
+
+func (interp *Interpreter) evaluate(program *ast.Program) (object.Object, error) {
+	evaluated := evaluator.Eval(program, interp.env)
+	if err, ok := evaluated.(*object.Error); ok {
+		return nil, err
+	}
+
+	if evaluated == nil {
+		return evaluator.NULL, nil
+	}
+	return evaluated, nil
+}
+
+func (interp *Interpreter) run(program *ast.Program) (object.Object, error) {
+	// Names are defined in a copy of the symbol table, code that doesn't
+	// compile must not leave variables behind that never got a value
+	symbolTable := interp.symbolTable.Copy()
+	comp := compiler.NewWithState(symbolTable, interp.constants)
+	err := comp.Compile(program)
+	if err != nil {
+		return nil, err
+	}
+
+	bytecode := comp.Bytecode()
+	interp.symbolTable = symbolTable
+	interp.constants = bytecode.Constants
+
+	machine := vm.NewWithGlobalStore(bytecode, interp.globals)
+	err = machine.Run()
+	if err != nil {
+		interp.nullUnsetGlobals()
+		return nil, err
+	}
+
+	// Only expressions leave their value as the last popped element
+	if len(program.Statements) == 0 {
+		return vm.Null, nil
+	}
+	switch program.Statements[len(program.Statements)-1].(type) {
+	case *ast.ExpressionStatement, *ast.ReturnStatement:
+		return machine.LastPopppedStackElem(), nil
+	}
+	return vm.Null, nil
+}
+
+// nullUnsetGlobals sets the globals that code failing to run defined but
+// never got to assign to null, so that using them afterwards is safe
+func (interp *Interpreter) nullUnsetGlobals() {
+	for _, symbol := range interp.symbolTable.Symbols() {
+		if symbol.Scope == compiler.GlobalScope && interp.globals[symbol.Index] == nil {
+			interp.globals[symbol.Index] = vm.Null
+		}
+	}
+}
+
+// SetGlobal binds name to value for the code run afterwards, defining the
+// variable if needed. Constants can't be changed.
+func (interp *Interpreter) SetGlobal(name string, value object.Object) error {
+	if interp.engine == EngineEval {
+		if err, ok := interp.env.Set(name, value).(*object.Error); ok {
+			return err
+		}
+		return nil
+	}
+
+	symbol, ok := interp.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		symbol = interp.symbolTable.Define(name)
+	}
+
+	if symbol.Constant {
+		return &object.Error{Message: "cannot assign to constant " + name}
+	}
+
+	interp.globals[symbol.Index] = value
+	return nil
+}
+
+// Register makes a Go function callable from Waffle code as name. Its
+// arguments and results are converted as object.NewGoBuiltin describes.
+//
+//	interp.Register("greet", func(name string, times int) (string, error) {
+//		...
+//	})
+func (interp *Interpreter) Register(name string, fn interface{}) error {
+	builtin, err := object.NewGoBuiltin(name, fn)
+	if err != nil {
+		return err
+	}
+	return interp.SetGlobal(name, builtin)
+}
+
+// GetGlobal returns the value of a global variable.
+func (interp *Interpreter) GetGlobal(name string) (object.Object, bool) {
+	if interp.engine == EngineEval {
+		return interp.env.Get(name)
+	}
+
+	symbol, ok := interp.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		return nil, false
+	}
+
+	value := interp.globals[symbol.Index]
+	return value, value != nil
+}
@@ -0,0 +1,335 @@
+package waffle
+
+import (
+	"errors"
+	"monkey/object"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var engines = []Engine{EngineVM, EngineEval}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2", "3"},
+		{`"a" + "b"`, "ab"},
+		{"let a = 1;", "null"},
+		{"", "null"},
+		{"let f = fn(x) { x * 2 }; f(4)", "8"},
+		{"return 5;", "5"},
+		{"if (false) { 1 }", "null"},
+		{"[1, 2][1]", "2"},
+	}
+
+	for _, engine := range engines {
+		for _, tt := range tests {
+			interp := New(engine)
+
+			result, err := interp.Eval(tt.input)
+			if err != nil {
+				t.Errorf("%s: unexpected error for %q: %s", engine, tt.input, err)
+				continue
+			}
+
+			if result.Inspect() != tt.expected {
+				t.Errorf("%s: wrong result for %q. want=%s, got=%s", engine, tt.input, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestEvalKeepsState(t *testing.T) {
+	for _, engine := range engines {
+		interp := New(engine)
+
+		inputs := []string{
+			"let count = 1;",
+			"const double = fn(x) { x * 2 };",
+			"count = double(count + 2);",
+			"count",
+		}
+
+		var result object.Object
+		for _, input := range inputs {
+			var err error
+			result, err = interp.Eval(input)
+			if err != nil {
+				t.Fatalf("%s: unexpected error for %q: %s", engine, input, err)
+			}
+		}
+
+		if result.Inspect() != "6" {
+			t.Errorf("%s: wrong result. want=6, got=%s", engine, result.Inspect())
+		}
+
+		_, err := interp.Eval("double = 1;")
+		if err == nil {
+			t.Errorf("%s: expected an error assigning to a constant", engine)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	for _, engine := range engines {
+		interp := New(engine)
+
+		_, err := interp.Eval("let = 1;")
+		parseErr, ok := err.(*ParseError)
+		if !ok {
+			t.Fatalf("%s: expected *ParseError, got=%T (%v)", engine, err, err)
+		}
+		if len(parseErr.Messages) == 0 {
+			t.Errorf("%s: ParseError has no messages", engine)
+		}
+
+		_, err = interp.Eval(`throw "boom"`)
+		runtimeErr, ok := err.(*object.Error)
+		if !ok {
+			t.Fatalf("%s: expected *object.Error, got=%T (%v)", engine, err, err)
+		}
+		if runtimeErr.Message != "boom" || runtimeErr.Pos.Line != 1 {
+			t.Errorf("%s: wrong error. got=%q at %s", engine, runtimeErr.Message, runtimeErr.Pos)
+		}
+
+		// A failed call doesn't break the interpreter
+		result, err := interp.Eval("1")
+		if err != nil || result.Inspect() != "1" {
+			t.Errorf("%s: interpreter unusable after an error. got=%v, %v", engine, result, err)
+		}
+	}
+}
+
+func TestEvalCompileErrorDefinesNothing(t *testing.T) {
+	interp := New(EngineVM)
+
+	_, err := interp.Eval("let y = 1; nope")
+	if err == nil || !strings.Contains(err.Error(), "undefined variable nope") {
+		t.Fatalf("expected compile error, got=%v", err)
+	}
+
+	_, err = interp.Eval("y + 1")
+	if err == nil || !strings.Contains(err.Error(), "undefined variable y") {
+		t.Errorf("y is still defined. got=%v", err)
+	}
+	if _, ok := interp.GetGlobal("y"); ok {
+		t.Errorf("GetGlobal found y")
+	}
+
+	result, err := interp.Eval("let y = 2; y + 1")
+	if err != nil || result.Inspect() != "3" {
+		t.Errorf("wrong result. got=%v, %v", result, err)
+	}
+}
+
+func TestEvalRunErrorSetsNull(t *testing.T) {
+	interp := New(EngineVM)
+
+	_, err := interp.Eval("let a = 1; let x = len(1);")
+	if err == nil {
+		t.Fatalf("expected a runtime error")
+	}
+
+	result, err := interp.Eval("a + 1")
+	if err != nil || result.Inspect() != "2" {
+		t.Errorf("wrong result for a + 1. got=%v, %v", result, err)
+	}
+
+	result, err = interp.Eval("x")
+	if err != nil || result != object.NULL {
+		t.Errorf("x is not null. got=%v, %v", result, err)
+	}
+
+	_, err = interp.Eval("x + 1")
+	if _, ok := err.(*object.Error); !ok {
+		t.Errorf("wrong error for x + 1. got=%v", err)
+	}
+}
+
+func TestEvalRecoversPanics(t *testing.T) {
+	for _, engine := range engines {
+		interp := New(engine)
+
+		_, err := interp.Eval("let y = 1; 1 / 0")
+		if _, ok := err.(*object.Error); !ok || !strings.Contains(err.Error(), "divide by zero") {
+			t.Errorf("%s: expected *object.Error for the panic, got=%T (%v)", engine, err, err)
+		}
+
+		result, err := interp.Eval("y + 1")
+		if err != nil || result.Inspect() != "2" {
+			t.Errorf("%s: interpreter unusable after a panic. got=%v, %v", engine, result, err)
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	for _, engine := range engines {
+		interp := New(engine)
+
+		err := interp.SetGlobal("limit", &object.Integer{Value: 10})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", engine, err)
+		}
+
+		result, err := interp.Eval("let over = limit + 5; limit * 2")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", engine, err)
+		}
+		if result.Inspect() != "20" {
+			t.Errorf("%s: wrong result. want=20, got=%s", engine, result.Inspect())
+		}
+
+		over, ok := interp.GetGlobal("over")
+		if !ok || over.Inspect() != "15" {
+			t.Errorf("%s: wrong global over. got=%v, %t", engine, over, ok)
+		}
+
+		err = interp.SetGlobal("limit", &object.Integer{Value: 1})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", engine, err)
+		}
+		result, _ = interp.Eval("limit")
+		if result.Inspect() != "1" {
+			t.Errorf("%s: SetGlobal did not replace the value. got=%s", engine, result.Inspect())
+		}
+
+		if _, ok := interp.GetGlobal("missing"); ok {
+			t.Errorf("%s: GetGlobal found an undefined variable", engine)
+		}
+		if _, ok := interp.GetGlobal("len"); ok {
+			t.Errorf("%s: GetGlobal returned a builtin", engine)
+		}
+
+		interp.Eval("const max = 3;")
+		err = interp.SetGlobal("max", &object.Integer{Value: 4})
+		if err == nil || err.Error() != "cannot assign to constant max" {
+			t.Errorf("%s: wrong error setting a constant. got=%v", engine, err)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`greet("bob", 2)`, "hello bob hello bob"},
+		{`sum([1, 2, 3])`, "6"},
+		{`max(1, 7, 3)`, "7"},
+		{`max()`, "0"},
+		{`keys({"b": 1, "a": 2})`, `[a, b]`},
+		{`half(3)`, "1.5"},
+		{`even(4)`, "true"},
+		{`if (even(3)) { 1 } else { 2 }`, "2"},
+		{`even(2) == true`, "true"},
+		{`nothing()`, "null"},
+		{`try { fail("bad") } catch (e) { e["message"] }`, "bad"},
+		{`try { at([1], 5) } catch (e) { e["type"] }`, "RuntimeError"},
+		{`let g = greet; g("x", 1)`, "hello x"},
+	}
+
+	for _, engine := range engines {
+		interp := New(engine)
+		registerTestFunctions(t, interp)
+
+		for _, tt := range tests {
+			result, err := interp.Eval(tt.input)
+			if err != nil {
+				t.Errorf("%s: unexpected error for %q: %s", engine, tt.input, err)
+				continue
+			}
+
+			if result.Inspect() != tt.expected {
+				t.Errorf("%s: wrong result for %q. want=%s, got=%s", engine, tt.input, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`greet("bob")`, "wrong number of arguments. got=1, want=2"},
+		{`greet(1, 2)`, "argument 1 to `greet`: cannot use INTEGER as string"},
+		{`sum([1, "a"])`, "argument 1 to `sum`: cannot use STRING as int"},
+		{`fail("bad")`, "bad"},
+		{`even(1.5)`, "argument 1 to `even`: cannot use FLOAT as int64"},
+		{`at([1], 5)`, "`at` panicked: runtime error: index out of range [5] with length 1"},
+	}
+
+	for _, engine := range engines {
+		interp := New(engine)
+		registerTestFunctions(t, interp)
+
+		for _, tt := range tests {
+			_, err := interp.Eval(tt.input)
+			if err == nil {
+				t.Errorf("%s: expected an error for %q", engine, tt.input)
+				continue
+			}
+
+			if err.Error() != tt.expected {
+				t.Errorf("%s: wrong error for %q. want=%q, got=%q", engine, tt.input, tt.expected, err)
+			}
+		}
+	}
+
+	interp := New(EngineVM)
+	if err := interp.Register("bad", 42); err == nil {
+		t.Errorf("expected an error registering a non-function")
+	}
+	if err := interp.Register("bad", func() (int, int) { return 1, 2 }); err == nil {
+		t.Errorf("expected an error registering a function with two results")
+	}
+}
+
+func registerTestFunctions(t *testing.T, interp *Interpreter) {
+	t.Helper()
+
+	functions := map[string]interface{}{
+		"greet": func(name string, times int) (string, error) {
+			return strings.TrimSpace(strings.Repeat("hello "+name+" ", times)), nil
+		},
+		"sum": func(numbers []int) int {
+			total := 0
+			for _, n := range numbers {
+				total += n
+			}
+			return total
+		},
+		"max": func(numbers ...int64) int64 {
+			var max int64
+			for _, n := range numbers {
+				if n > max {
+					max = n
+				}
+			}
+			return max
+		},
+		"keys": func(h map[string]object.Object) []string {
+			keys := []string{}
+			for k := range h {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return keys
+		},
+		"half":    func(n float64) float64 { return n / 2 },
+		"even":    func(n int64) bool { return n%2 == 0 },
+		"nothing": func() {},
+		"fail":    func(msg string) error { return errors.New(msg) },
+		"at":      func(numbers []int, i int) int { return numbers[i] },
+	}
+
+	for name, fn := range functions {
+		err := interp.Register(name, fn)
+		if err != nil {
+			t.Fatalf("could not register %s: %s", name, err)
+		}
+	}
+}